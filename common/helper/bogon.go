@@ -0,0 +1,159 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrBlockedTarget = errors.New("blocked target")
+
+// Private, loopback, link-local, documentation and other never routable ranges
+var bogonPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+var (
+	allowedPrefixes = []netip.Prefix{}
+	allowedHosts    = []string{}
+)
+
+// Let local test setups through, entries are ips, cidrs or hostnames
+func SetNetAllowlist(entries []string) {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			allowedPrefixes = append(allowedPrefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			allowedPrefixes = append(allowedPrefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			allowedHosts = append(allowedHosts, strings.ToLower(entry))
+		}
+	}
+}
+
+// Drop every allowlist entry, mostly for tests that set their own
+func ResetNetAllowlist() {
+	allowedPrefixes = []netip.Prefix{}
+	allowedHosts = []string{}
+}
+
+func IsAllowedHost(host string) bool {
+	return slices.Contains(allowedHosts, strings.ToLower(strings.TrimSuffix(host, ".")))
+}
+
+func IsBogonIP(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range bogonPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reject bogon ip unless allowlisted
+func CheckPublicIP(addr netip.Addr) error {
+	addr = addr.Unmap()
+	for _, prefix := range allowedPrefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if IsBogonIP(addr) {
+		return fmt.Errorf("%w: %s is not public", ErrBlockedTarget, addr)
+	}
+
+	return nil
+}
+
+// Reject host if it is bogon ip or localhost name, without resolving anything
+func CheckPublicLiteral(host string) error {
+	host = strings.Trim(host, "[]")
+	if IsAllowedHost(host) {
+		return nil
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return CheckPublicIP(addr)
+	}
+
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("%w: %s is not public", ErrBlockedTarget, host)
+	}
+
+	return nil
+}
+
+// Reject host if it is or resolves to bogon ip, unresolvable hosts are left to fail on their own
+func CheckPublicHost(host string) error {
+	if err := CheckPublicLiteral(host); err != nil {
+		return err
+	}
+
+	host = strings.Trim(host, "[]")
+	if IsAllowedHost(host) {
+		return nil
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range addrs {
+		if err := CheckPublicIP(addr); err != nil {
+			return fmt.Errorf("%w: %s resolves to %s", ErrBlockedTarget, host, addr)
+		}
+	}
+
+	return nil
+}
+
+// Dialer control rejecting bogon ips at connect time, catches redirects and dns rebinding
+func PublicOnlyControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	return CheckPublicIP(addrPort.Addr())
+}
@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Quota and expiry advertised by subscription-userinfo header, in bytes and unix seconds
+type SubUserinfoStruct struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+	Total    int64 `json:"total"`
+	Expire   int64 `json:"expire"`
+}
+
+// Parse "upload=1; download=2; total=3; expire=4", nil when nothing usable
+func ParseSubUserinfo(header string) *SubUserinfoStruct {
+	var (
+		userinfo = SubUserinfoStruct{}
+		found    = false
+	)
+
+	for _, field := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+
+		// Some panels send floats or empty values
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			userinfo.Upload = int64(number)
+		case "download":
+			userinfo.Download = int64(number)
+		case "total":
+			userinfo.Total = int64(number)
+		case "expire":
+			userinfo.Expire = int64(number)
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if !found {
+		return nil
+	}
+
+	return &userinfo
+}
+
+// Remaining bytes, -1 if subscription has no quota
+func (userinfo *SubUserinfoStruct) Remaining() int64 {
+	if userinfo.Total <= 0 {
+		return -1
+	}
+
+	return max(userinfo.Total-userinfo.Upload-userinfo.Download, 0)
+}
+
+func (userinfo *SubUserinfoStruct) IsExhausted() bool {
+	return userinfo.Remaining() == 0
+}
+
+// Zero expire means it never expires
+func (userinfo *SubUserinfoStruct) IsExpired(now time.Time) bool {
+	return userinfo.Expire > 0 && userinfo.Expire <= now.Unix()
+}
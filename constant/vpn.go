@@ -1,5 +1,9 @@
 package constant
 
 var (
-	ACCEPTED_TYPES = []string{"vless://", "vmess://", "trojan://", "ss://"}
+	ACCEPTED_TYPES          = []string{"vless://", "vmess://", "trojan://", "ss://", "hysteria2://", "hy2://", "tuic://", "anytls://"}
+	ACCEPTED_OUTBOUND_TYPES = []string{"vless", "vmess", "trojan", "shadowsocks", "hysteria2", "tuic", "anytls"}
+
+	// Outbounds running on top of QUIC, these can't be fronted by CDN
+	QUIC_OUTBOUND_TYPES = []string{"hysteria2", "tuic"}
 )
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"runtime"
 	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +23,39 @@ import (
 
 var safePattern = regexp.MustCompile("[^A-Za-z0-9-]")
 
+// Quote for sql string literal, credentials must be stored as is
+func escapeString(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+// Columns added after the initial proxies schema
+var proxiesMigrationColumns = []string{
+	"obfs STRING",
+	"obfs_password STRING",
+	"congestion_control STRING",
+	"udp_relay_mode STRING",
+	"shadowtls_version INT2",
+	"shadowtls_password STRING",
+	"sub_upload INT8",
+	"sub_download INT8",
+	"sub_total INT8",
+	"sub_remaining INT8",
+	"sub_expire INT8",
+	"test_hosts STRING",
+	"connect_ms INT4",
+	"tls_ms INT4",
+	"ttfb_ms INT4",
+	"jitter_ms INT4",
+	"throughput_kbps INT4",
+	"udp INT2",
+	"asn INT8",
+	"geo_source STRING",
+	"entry_ip STRING",
+	"entry_country_code STRING",
+	"entry_org STRING",
+	"entry_asn INT8",
+}
+
 type databaseStruct struct {
 	client          *sql.DB
 	dbUrl           string
@@ -32,6 +66,8 @@ type databaseStruct struct {
 	queries         []string
 	ErrorValues     []string
 	ApiToken        string
+	maxLatency      int64
+	udpOnly         bool
 }
 
 func MakeDatabase() *databaseStruct {
@@ -51,6 +87,16 @@ func MakeDatabase() *databaseStruct {
 	return &dbInstance
 }
 
+// Drop accounts slower than ms to first byte, 0 keeps all
+func (db *databaseStruct) SetMaxLatency(ms int64) {
+	db.maxLatency = ms
+}
+
+// Only save accounts that passed udp probe
+func (db *databaseStruct) SetUDPOnly(udpOnly bool) {
+	db.udpOnly = udpOnly
+}
+
 func (db *databaseStruct) connect() {
 	client, err := sql.Open("libsql", fmt.Sprintf("%s?authToken=%s", db.dbUrl, db.dbToken))
 	if err != nil {
@@ -95,7 +141,31 @@ func (db *databaseStruct) createTableSafe() {
 			region STRING,
 			org STRING,
 			vpn STRING,
-			raw STRING
+			raw STRING,
+			obfs STRING,
+			obfs_password STRING,
+			congestion_control STRING,
+			udp_relay_mode STRING,
+			shadowtls_version INT2,
+			shadowtls_password STRING,
+			sub_upload INT8,
+			sub_download INT8,
+			sub_total INT8,
+			sub_remaining INT8,
+			sub_expire INT8,
+			test_hosts STRING,
+			connect_ms INT4,
+			tls_ms INT4,
+			ttfb_ms INT4,
+			jitter_ms INT4,
+			throughput_kbps INT4,
+			udp INT2,
+			asn INT8,
+			geo_source STRING,
+			entry_ip STRING,
+			entry_country_code STRING,
+			entry_org STRING,
+			entry_asn INT8
 		);`
 	)
 
@@ -105,6 +175,15 @@ func (db *databaseStruct) createTableSafe() {
 		db.logger.Error(err.Error())
 		os.Exit(1)
 	}
+
+	// Existing tables won't get new columns from CREATE TABLE IF NOT EXISTS
+	for _, column := range proxiesMigrationColumns {
+		if _, err := db.client.Exec(fmt.Sprintf("ALTER TABLE proxies ADD COLUMN %s;", column)); err == nil {
+			db.logger.Info(fmt.Sprintf("[db] Column added: %s", column))
+		} else if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
+			db.logger.Error(err.Error())
+		}
+	}
 }
 
 func (db *databaseStruct) Save(results []sandbox.TestResultStruct) error {
@@ -168,6 +247,10 @@ func (db *databaseStruct) buildInsertQuery(results []sandbox.TestResultStruct) [
 
 	tableFieldValues := []ProxyFieldStruct{}
 	for _, result := range results {
+		if db.udpOnly && !result.UDP {
+			continue
+		}
+
 		var (
 			fieldValues = ProxyFieldStruct{}
 			outbound    = result.Outbound
@@ -179,18 +262,23 @@ func (db *databaseStruct) buildInsertQuery(results []sandbox.TestResultStruct) [
 		)
 		json.Unmarshal(outboundByte, &outboundMapping)
 
-		// Geoip
-		fieldValues.Ip = result.ConfigGeoip.IP
-		fieldValues.CountryCode = result.ConfigGeoip.Country
-		fieldValues.Region = helper.GetRegionFromCC(fieldValues.CountryCode)
-		fieldValues.Org = result.ConfigGeoip.AsOrganization
-
 		// Common
 		fieldValues.VPN = outbound.Type
 		fieldValues.Server = outboundMapping["server"].(string)
 		fieldValues.ServerPort = int(outboundMapping["server_port"].(float64))
 		fieldValues.Transport = "tcp"
 		fieldValues.Raw = result.RawConfig
+		fieldValues.UDP = result.UDP
+
+		// Subscription quota
+		fieldValues.SubRemaining = -1
+		if userinfo := result.Userinfo; userinfo != nil {
+			fieldValues.SubUpload = userinfo.Upload
+			fieldValues.SubDownload = userinfo.Download
+			fieldValues.SubTotal = userinfo.Total
+			fieldValues.SubRemaining = userinfo.Remaining()
+			fieldValues.SubExpire = userinfo.Expire
+		}
 
 		// Here we go assertion hell
 		if uuid, ok := outboundMapping["uuid"].(string); ok {
@@ -215,6 +303,22 @@ func (db *databaseStruct) buildInsertQuery(results []sandbox.TestResultStruct) [
 			fieldValues.PluginOpts = pluginOpts
 		}
 
+		// Hysteria2 and TUIC
+		if obfsMapping, ok := outboundMapping["obfs"].(map[string]any); ok {
+			if obfs, ok := obfsMapping["type"].(string); ok {
+				fieldValues.Obfs = obfs
+			}
+			if obfsPassword, ok := obfsMapping["password"].(string); ok {
+				fieldValues.ObfsPassword = obfsPassword
+			}
+		}
+		if congestionControl, ok := outboundMapping["congestion_control"].(string); ok {
+			fieldValues.CongestionControl = congestionControl
+		}
+		if udpRelayMode, ok := outboundMapping["udp_relay_mode"].(string); ok {
+			fieldValues.UDPRelayMode = udpRelayMode
+		}
+
 		// Transport
 		if outboundMapping["transport"] != nil {
 			transportMapping := outboundMapping["transport"].(map[string]any)
@@ -264,9 +368,77 @@ func (db *databaseStruct) buildInsertQuery(results []sandbox.TestResultStruct) [
 			tlsStr = "TLS"
 		}
 
+		// Shadow TLS wraps the main outbound, the server lives on the detour
+		if result.Detour.Type == "shadowtls" {
+			var (
+				detourMapping = map[string]any{}
+				detourByte, _ = json.Marshal(result.Detour.Options)
+			)
+			json.Unmarshal(detourByte, &detourMapping)
+
+			fieldValues.Transport = "shadowtls"
+			fieldValues.TLS = true
+			tlsStr = "TLS"
+
+			if server, ok := detourMapping["server"].(string); ok {
+				fieldValues.Server = server
+			}
+			if serverPort, ok := detourMapping["server_port"].(float64); ok {
+				fieldValues.ServerPort = int(serverPort)
+			}
+			if version, ok := detourMapping["version"].(float64); ok {
+				fieldValues.ShadowTLSVersion = int(version)
+			}
+			if password, ok := detourMapping["password"].(string); ok {
+				fieldValues.ShadowTLSPassword = password
+			}
+			if detourMapping["tls"] != nil {
+				tlsMapping := detourMapping["tls"].(map[string]any)
+				if insecure, ok := tlsMapping["insecure"].(bool); ok {
+					fieldValues.Insecure = insecure
+				}
+				if sni, ok := tlsMapping["server_name"].(string); ok {
+					fieldValues.SNI = sni
+				}
+			}
+		}
+
 		for _, connMode := range result.TestPassed {
+			var testHosts = []string{}
+			for _, entry := range result.PassedEntries {
+				if entry.Mode == connMode {
+					testHosts = append(testHosts, entry.String())
+				}
+			}
+
+			var (
+				modeResult = result.Modes[connMode]
+				latency    = modeResult.Latency
+			)
+			if db.maxLatency > 0 && latency.TTFB > db.maxLatency {
+				continue
+			}
+
+			// Each mode may exit somewhere else
+			fieldValues.Ip = modeResult.ExitGeoip.IP
+			fieldValues.CountryCode = modeResult.ExitGeoip.Country
+			fieldValues.Region = helper.GetRegionFromCC(fieldValues.CountryCode)
+			fieldValues.Org = modeResult.ExitGeoip.AsOrganization
+			fieldValues.ASN = modeResult.ExitGeoip.ASN
+			fieldValues.GeoSource = modeResult.ExitGeoip.Source
+			fieldValues.EntryIp = modeResult.EntryGeoip.IP
+			fieldValues.EntryCountryCode = modeResult.EntryGeoip.Country
+			fieldValues.EntryOrg = modeResult.EntryGeoip.AsOrganization
+			fieldValues.EntryASN = modeResult.EntryGeoip.ASN
+
 			fieldValues.ConnMode = connMode
-			fieldValues.Remark = strings.ToUpper(fmt.Sprintf("%d %s %s %s %s %s", len(tableFieldValues)+1, helper.CCToEmoji(fieldValues.CountryCode), fieldValues.Org, fieldValues.Transport, connMode, tlsStr))
+			fieldValues.TestHosts = strings.Join(testHosts, ",")
+			fieldValues.ConnectMs = latency.Connect
+			fieldValues.TLSMs = latency.TLS
+			fieldValues.TTFBMs = latency.TTFB
+			fieldValues.JitterMs = latency.Jitter
+			fieldValues.ThroughputKbps = modeResult.Throughput
+			fieldValues.Remark = strings.ToUpper(fmt.Sprintf("%s %s %s %s %s", helper.CCToEmoji(fieldValues.CountryCode), fieldValues.Org, fieldValues.Transport, connMode, tlsStr))
 
 			// Check if same account exists
 			if !db.checkIsExists(fieldValues) {
@@ -278,6 +450,17 @@ func (db *databaseStruct) buildInsertQuery(results []sandbox.TestResultStruct) [
 	results = nil
 	runtime.GC()
 
+	// Fastest first, unmeasured last, numbered in that order
+	sort.SliceStable(tableFieldValues, func(i, j int) bool {
+		if (tableFieldValues[i].TTFBMs == 0) != (tableFieldValues[j].TTFBMs == 0) {
+			return tableFieldValues[j].TTFBMs == 0
+		}
+		return tableFieldValues[i].TTFBMs < tableFieldValues[j].TTFBMs
+	})
+	for i := range tableFieldValues {
+		tableFieldValues[i].Remark = fmt.Sprintf("%d %s", i+1, tableFieldValues[i].Remark)
+	}
+
 	// Build queries
 	values := []string{}
 	for _, fieldValue := range tableFieldValues {
@@ -306,7 +489,31 @@ func (db *databaseStruct) buildInsertQuery(results []sandbox.TestResultStruct) [
 		value += fmt.Sprintf("'%s', ", fieldValue.Region)
 		value += fmt.Sprintf("'%s', ", fieldValue.Org)
 		value += fmt.Sprintf("'%s', ", fieldValue.VPN)
-		value += fmt.Sprintf("'%s'", fieldValue.Raw)
+		value += fmt.Sprintf("'%s', ", fieldValue.Raw)
+		value += fmt.Sprintf("'%s', ", fieldValue.Obfs)
+		value += fmt.Sprintf("'%s', ", escapeString(fieldValue.ObfsPassword))
+		value += fmt.Sprintf("'%s', ", fieldValue.CongestionControl)
+		value += fmt.Sprintf("'%s', ", fieldValue.UDPRelayMode)
+		value += fmt.Sprintf("%d, ", fieldValue.ShadowTLSVersion)
+		value += fmt.Sprintf("'%s', ", escapeString(fieldValue.ShadowTLSPassword))
+		value += fmt.Sprintf("%d, ", fieldValue.SubUpload)
+		value += fmt.Sprintf("%d, ", fieldValue.SubDownload)
+		value += fmt.Sprintf("%d, ", fieldValue.SubTotal)
+		value += fmt.Sprintf("%d, ", fieldValue.SubRemaining)
+		value += fmt.Sprintf("%d, ", fieldValue.SubExpire)
+		value += fmt.Sprintf("'%s', ", fieldValue.TestHosts)
+		value += fmt.Sprintf("%d, ", fieldValue.ConnectMs)
+		value += fmt.Sprintf("%d, ", fieldValue.TLSMs)
+		value += fmt.Sprintf("%d, ", fieldValue.TTFBMs)
+		value += fmt.Sprintf("%d, ", fieldValue.JitterMs)
+		value += fmt.Sprintf("%d, ", fieldValue.ThroughputKbps)
+		value += fmt.Sprintf("%t, ", fieldValue.UDP)
+		value += fmt.Sprintf("%d, ", fieldValue.ASN)
+		value += fmt.Sprintf("'%s', ", fieldValue.GeoSource)
+		value += fmt.Sprintf("'%s', ", fieldValue.EntryIp)
+		value += fmt.Sprintf("'%s', ", fieldValue.EntryCountryCode)
+		value += fmt.Sprintf("'%s', ", fieldValue.EntryOrg)
+		value += fmt.Sprintf("%d", fieldValue.EntryASN)
 
 		value += ")"
 
@@ -336,7 +543,31 @@ func (db *databaseStruct) buildInsertQuery(results []sandbox.TestResultStruct) [
 		REGION,
 		ORG,
 		VPN,
-		RAW
+		RAW,
+		OBFS,
+		OBFS_PASSWORD,
+		CONGESTION_CONTROL,
+		UDP_RELAY_MODE,
+		SHADOWTLS_VERSION,
+		SHADOWTLS_PASSWORD,
+		SUB_UPLOAD,
+		SUB_DOWNLOAD,
+		SUB_TOTAL,
+		SUB_REMAINING,
+		SUB_EXPIRE,
+		TEST_HOSTS,
+		CONNECT_MS,
+		TLS_MS,
+		TTFB_MS,
+		JITTER_MS,
+		THROUGHPUT_KBPS,
+		UDP,
+		ASN,
+		GEO_SOURCE,
+		ENTRY_IP,
+		ENTRY_COUNTRY_CODE,
+		ENTRY_ORG,
+		ENTRY_ASN
 	) VALUES`
 
 	// Filter bad and build insert queries
@@ -385,8 +616,8 @@ func (db *databaseStruct) validateQuery(query string) error {
 }
 
 func (db *databaseStruct) makeUniqueId(field ProxyFieldStruct) string {
-	// Server Port, UUID, Password, Plugin Opts, Path, Transport, Conn Mode, Country, Org, VPN
-	uid := fmt.Sprintf("%d_%s_%s_%s_%s_%s_%s_%s_%s_%s", field.ServerPort, field.UUID, field.Password, field.PluginOpts, field.Path, field.Transport, field.ConnMode, field.CountryCode, field.Org, field.VPN)
+	// Server Port, UUID, Password, Plugin Opts, Path, Transport, Conn Mode, Country, Org, VPN, Obfs Password, ShadowTLS Password
+	uid := fmt.Sprintf("%d_%s_%s_%s_%s_%s_%s_%s_%s_%s_%s_%s", field.ServerPort, field.UUID, field.Password, field.PluginOpts, field.Path, field.Transport, field.ConnMode, field.CountryCode, field.Org, field.VPN, field.ObfsPassword, field.ShadowTLSPassword)
 
 	return uid
 }
@@ -30,4 +30,44 @@ type ProxyFieldStruct struct {
 
 	// Additional fields
 	Raw string `json:"raw"` // 23
+
+	// Protocol specific fields
+	Obfs              string `json:"obfs,omitempty"`               // 24
+	ObfsPassword      string `json:"obfs_password,omitempty"`      // 25
+	CongestionControl string `json:"congestion_control,omitempty"` // 26
+	UDPRelayMode      string `json:"udp_relay_mode,omitempty"`     // 27
+	ShadowTLSVersion  int    `json:"shadowtls_version,omitempty"`  // 28
+	ShadowTLSPassword string `json:"shadowtls_password,omitempty"` // 29
+
+	// Subscription fields, -1 remaining means unlimited and 0 expire means never
+	SubUpload    int64 `json:"sub_upload,omitempty"`    // 30
+	SubDownload  int64 `json:"sub_download,omitempty"`  // 31
+	SubTotal     int64 `json:"sub_total,omitempty"`     // 32
+	SubRemaining int64 `json:"sub_remaining,omitempty"` // 33
+	SubExpire    int64 `json:"sub_expire,omitempty"`    // 34
+
+	// Test matrix hosts the conn mode passed with
+	TestHosts string `json:"test_hosts,omitempty"` // 35
+
+	// Median latency of the conn mode in milliseconds, 0 if not measured
+	ConnectMs int64 `json:"connect_ms,omitempty"` // 36
+	TLSMs     int64 `json:"tls_ms,omitempty"`     // 37
+	TTFBMs    int64 `json:"ttfb_ms,omitempty"`    // 38
+	JitterMs  int64 `json:"jitter_ms,omitempty"`  // 39
+
+	// Download speed of the conn mode in kilobits per second, 0 if not tested
+	ThroughputKbps int64 `json:"throughput_kbps,omitempty"` // 40
+
+	// Passed dns query sent over udp through the node
+	UDP bool `json:"udp,omitempty"` // 41
+
+	// Geo details, source is mmdb or echo endpoint host, empty if geo is unknown
+	ASN       int64  `json:"asn,omitempty"`        // 42
+	GeoSource string `json:"geo_source,omitempty"` // 43
+
+	// Geo of the server the conn mode dials first, empty without offline database
+	EntryIp          string `json:"entry_ip,omitempty"`           // 44
+	EntryCountryCode string `json:"entry_country_code,omitempty"` // 45
+	EntryOrg         string `json:"entry_org,omitempty"`          // 46
+	EntryASN         int64  `json:"entry_asn,omitempty"`          // 47
 }
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/base64"
+	"flag"
 	"fmt"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/FoolVPN-ID/megalodon/common/helper"
 	database "github.com/FoolVPN-ID/megalodon/db"
 	logger "github.com/FoolVPN-ID/megalodon/log"
 	"github.com/FoolVPN-ID/megalodon/provider"
@@ -16,6 +21,23 @@ import (
 func main() {
 	godotenv.Load()
 
+	var (
+		subFilePath = flag.String("sublist", "./resources/sublist.json", "Sub list file containing http(s):// or file:// urls")
+		nodesDir    = flag.String("nodes-dir", "./resources/nodes", "Directory of local .txt/.yaml/.json node dumps")
+		fromStdin   = flag.Bool("stdin", false, "Read additional nodes from stdin")
+		maxDepth    = flag.Int("max-depth", 1, "Depth of nested subscriptions to follow, 0 disables discovery")
+		recordDir   = flag.String("record", "", "Archive every fetched subscription into dir")
+		replayDir   = flag.String("replay", "", "Read subscriptions only from archive dir, no network")
+		matrixPath  = flag.String("test-matrix", "./resources/test_matrix.json", "Test matrix file of named modes, hosts, ports and probe urls")
+		maxLatency  = flag.Int64("max-latency", 0, "Skip saving accounts slower than ms to first byte, 0 keeps all")
+		udpResolver = flag.String("udp-resolver", "1.1.1.1:53", "DNS resolver queried through node to test udp, empty disables")
+		udpOnly     = flag.Bool("udp-only", false, "Only save accounts that passed udp test")
+		countryDB   = flag.String("geo-country-db", "./resources/GeoLite2-Country.mmdb", "MaxMind format country database, skipped if missing")
+		asnDB       = flag.String("geo-asn-db", "./resources/GeoLite2-ASN.mmdb", "MaxMind format asn database, skipped if missing")
+		geoChain    = flag.String("geo-endpoints", strings.Join(sandbox.DEFAULT_GEO_ENDPOINTS, ","), "Comma separated echo endpoints asked for exit ip and geo, in order")
+	)
+	flag.Parse()
+
 	var (
 		bot      = bot.MakeTGgBot()
 		logger   = logger.MakeLogger()
@@ -32,19 +54,51 @@ func main() {
 	// Send notification to admin
 	bot.SendTextToAdmin("Megalodon started!")
 
+	// Internal targets allowed for local test setups
+	helper.SetNetAllowlist(strings.Split(os.Getenv("NET_ALLOWLIST"), ","))
+
 	// Nodes gathering
 	logger.Info("Gathering nodes...")
-	prov.GatherSubFile()
+	prov.LoadStats()
+	prov.SetMaxDepth(*maxDepth)
+	if *recordDir != "" {
+		if err := prov.SetRecord(*recordDir); err != nil {
+			panic(err)
+		}
+	}
+	if *replayDir != "" {
+		if err := prov.SetReplay(*replayDir); err != nil {
+			panic(err)
+		}
+	}
+	if fetchProxy := os.Getenv("FETCH_PROXY"); fetchProxy != "" {
+		prov.AddFetchProxy("upstream", fetchProxy)
+	}
+	prov.GatherSubFile(*subFilePath)
+	prov.AddSource("dir", *nodesDir)
+	if *fromStdin {
+		prov.AddSource("stdin", "")
+	}
 	prov.GatherNodes()
 
-	// Load blacklist
+	// Load blacklist and test matrix
 	sb.LoadBlacklist()
+	sb.SetMaxResults(maxNodes)
+	if err := sb.LoadTestMatrix(*matrixPath); err != nil {
+		logger.Error(err.Error())
+	}
+	sb.SetUDPResolver(*udpResolver)
+	sb.SetGeoEndpoints(strings.Split(*geoChain, ","))
+	if err := sb.LoadGeoDatabases(*countryDB, *asnDB); err != nil {
+		logger.Error(err.Error())
+	}
 
 	// Goroutine goes here 💪🏻
 	var (
-		wg     = sync.WaitGroup{}
-		queue  = make(chan struct{}, 200)
-		isDone = false
+		wg        = sync.WaitGroup{}
+		queue     = make(chan struct{}, 4)
+		batchSize = 250
+		isDone    = false
 	)
 
 	// Report progress each minute
@@ -62,43 +116,95 @@ func main() {
 		}
 	}()
 
+	// Each batch shares sing-box instances, a few batches run at once
+	testNodes := func(nodes []string) {
+		nodesCount := len(nodes)
+		for i := 0; i < nodesCount; i += batchSize {
+			if sb.IsFull() {
+				break
+			}
+
+			wg.Add(1)
+			queue <- struct{}{}
+
+			go func(batch []string, offset, maxCount int) {
+				defer func() {
+					if err := recover(); err != nil {
+						logger.Error(fmt.Sprintf("Recover from panic: %v", err))
+					}
+
+					wg.Done()
+					<-queue
+				}()
+
+				sb.TestBatch(batch, offset, maxCount)
+			}(nodes[i:min(i+batchSize, nodesCount)], i, nodesCount)
+		}
+
+		// Wait for all concurrency to be done
+		logger.Info("Waiting for goroutines...")
+		wg.Wait()
+	}
+
 	logger.Info("Processing...")
-	nodesCount := len(prov.Nodes)
-	for i, rawConfig := range prov.Nodes {
-		wg.Add(1)
-		queue <- struct{}{}
-
-		// logger.Info(fmt.Sprintf("[%d/%d] Testing..., current succeed: %d", i, nodesCount, len(sb.Results)))
-		go func(node string, currentCount, maxCount int) {
-			defer func() {
-				if err := recover(); err != nil {
-					logger.Error(fmt.Sprintf("Recover from panic: %v", err))
-				}
-
-				wg.Done()
-				<-queue
-			}()
-
-			if err := sb.TestConfig(node, currentCount, maxCount); err != nil {
+	testNodes(prov.Nodes)
+
+	// Dumps dropped into nodes dir while testing get their turn too
+	for newNodes := prov.PollDirs(); len(newNodes) > 0 && !sb.IsFull(); newNodes = prov.PollDirs() {
+		logger.Info(fmt.Sprintf("Testing %d nodes from new dumps...", len(newNodes)))
+		testNodes(newNodes)
+	}
+
+	// Retry blocked subscriptions through the first validated node still alive
+	if prov.HasFailedSources() {
+		for _, result := range sb.Results {
+			proxyUrl, closeProxy, err := sb.StartProxy(result)
+			if err != nil {
 				logger.Error(err.Error())
+				continue
 			}
-		}(rawConfig, i, nodesCount)
 
-		if len(sb.Results) > maxNodes {
+			logger.Info("Retrying failed sources through validated node...")
+			prov.AddFetchProxy("node", proxyUrl)
+			testNodes(prov.RetryFailedSources())
+			closeProxy()
 			break
 		}
 	}
 
-	// Wait for all concurrency to be done
-	logger.Info("Waiting for goroutines...")
-	wg.Wait()
-
 	// Finishing
 	isDone = true
 	sb.SaveBlacklist()
 
+	failureSummary := sb.FailureSummary()
+	logger.Info(fmt.Sprintf("Failed: %d %s", len(sb.Failures), failureSummary))
+	bot.SendTextToAdmin(fmt.Sprintf("Account failed: %d\n%s", len(sb.Failures), failureSummary))
+
+	// Credit sources for their live nodes, blame them for dead ones and attach subscription quota
+	for i, result := range sb.Results {
+		if rawConfig, err := base64.StdEncoding.DecodeString(result.RawConfig); err == nil {
+			prov.RecordTestResult(string(rawConfig), result.TestPassed)
+			sb.Results[i].Userinfo = prov.GetNodeUserinfo(string(rawConfig))
+		}
+	}
+	for _, failure := range sb.Failures {
+		if rawConfig, err := base64.StdEncoding.DecodeString(failure.RawConfig); err == nil {
+			prov.RecordTestFailure(string(rawConfig), string(failure.Reason))
+		}
+	}
+
+	// Replayed subscriptions are old, don't let them overwrite stats or accounts
+	if prov.IsReplay() {
+		logger.Info("Replay run, stats and results are not saved")
+		return
+	}
+	prov.SaveStats()
+	bot.SendTextFileToAdmin(fmt.Sprintf("sources_%v.txt", time.Now().Unix()), prov.StatsReport(), "Source Report")
+
 	// Save results to database
 	logger.Info("Saving results to database...")
+	db.SetMaxLatency(*maxLatency)
+	db.SetUDPOnly(*udpOnly)
 	bot.SendTextToAdmin("Saving result to database...")
 	if err := db.Save(sb.Results); err != nil {
 		panic(err)
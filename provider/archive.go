@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+const (
+	ARCHIVE_MANIFEST_FILENAME = "manifest.json"
+	SUBLIST_ARCHIVE_KEY       = "sublist://"
+)
+
+type archiveEntryStruct struct {
+	URL       string      `json:"url"`
+	Status    int         `json:"status"`
+	Headers   http.Header `json:"headers"`
+	FetchedAt time.Time   `json:"fetched_at"`
+	Body      string      `json:"body"`
+}
+
+type archiveManifestStruct struct {
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Store every fetched body with its headers into dir
+func (prov *providerStruct) SetRecord(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	manifestByte, err := json.MarshalIndent(archiveManifestStruct{RecordedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	prov.recordDir = dir
+	return os.WriteFile(filepath.Join(dir, ARCHIVE_MANIFEST_FILENAME), manifestByte, 0644)
+}
+
+// Serve every fetch from archive in dir, as if it was recording time
+func (prov *providerStruct) SetReplay(dir string) error {
+	manifestString, err := helper.ReadFileAsString(filepath.Join(dir, ARCHIVE_MANIFEST_FILENAME))
+	if err != nil {
+		return err
+	}
+
+	manifest := archiveManifestStruct{}
+	if err := json.Unmarshal([]byte(manifestString), &manifest); err != nil {
+		return err
+	}
+
+	prov.replayDir = dir
+	prov.replayAt = manifest.RecordedAt
+	return nil
+}
+
+// Replay run only looks back at a recorded one, nothing it finds should be saved
+func (prov *providerStruct) IsReplay() bool {
+	return prov.replayDir != ""
+}
+
+// Current time, frozen to recording time on replay so dated urls resolve the same
+func (prov *providerStruct) now() time.Time {
+	if prov.replayDir != "" {
+		return prov.replayAt
+	}
+
+	return time.Now()
+}
+
+// Local bodies go through archive too, so replay doesn't depend on disk or stdin
+func (prov *providerStruct) readLocal(key string, read func() (string, error)) (string, error) {
+	if prov.replayDir != "" {
+		return prov.replayArchive(key)
+	}
+
+	body, err := read()
+	if err == nil {
+		prov.recordArchive(key, 200, nil, body)
+	}
+
+	return body, err
+}
+
+func (prov *providerStruct) recordArchive(rawUrl string, status int, headers http.Header, body string) {
+	if prov.recordDir == "" {
+		return
+	}
+
+	entryByte, err := json.Marshal(archiveEntryStruct{
+		URL:       rawUrl,
+		Status:    status,
+		Headers:   headers,
+		FetchedAt: time.Now(),
+		Body:      body,
+	})
+	if err != nil {
+		prov.logger.Error(err.Error())
+		return
+	}
+
+	if err := os.WriteFile(getArchivePath(prov.recordDir, rawUrl), entryByte, 0644); err != nil {
+		prov.logger.Error(err.Error())
+	}
+}
+
+func (prov *providerStruct) replayArchive(rawUrl string) (string, error) {
+	entryByte, err := os.ReadFile(getArchivePath(prov.replayDir, rawUrl))
+	if err != nil {
+		return "", &fetchErrorStruct{URL: rawUrl, Reason: "not in archive"}
+	}
+
+	entry := archiveEntryStruct{}
+	if err := json.Unmarshal(entryByte, &entry); err != nil || entry.URL != rawUrl {
+		return "", &fetchErrorStruct{URL: rawUrl, Reason: "corrupted archive entry"}
+	}
+
+	if entry.Status != 200 {
+		return "", &fetchErrorStruct{URL: rawUrl, Status: entry.Status, Reason: fmt.Sprintf("archived status %d", entry.Status)}
+	}
+
+	prov.setUserinfo(rawUrl, entry.Headers.Get("Subscription-Userinfo"))
+	return entry.Body, nil
+}
+
+func getArchivePath(dir, url string) string {
+	return filepath.Join(dir, helper.GetMD5FromString(url)+".json")
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	t.Chdir(t.TempDir())
+	helper.SetNetAllowlist([]string{"127.0.0.1"})
+	t.Cleanup(helper.ResetNetAllowlist)
+
+	var hits = 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Subscription-Userinfo", "upload=0; download=0; total=1")
+		w.Write([]byte(strings.Join([]string{
+			"trojan://pass@1.1.1.1:443#first-recorded-node",
+			"trojan://pass@1.0.0.1:443#second-recorded-node",
+		}, "\n")))
+	}))
+
+	var (
+		archiveDir  = t.TempDir()
+		subFilePath = filepath.Join(t.TempDir(), "sublist.json")
+	)
+	os.WriteFile(subFilePath, fmt.Appendf(nil, `[{"type": "uri", "url": "%s/sub.txt"}]`, server.URL), 0644)
+
+	recording := MakeSubProvider()
+	if err := recording.SetRecord(archiveDir); err != nil {
+		t.Fatal(err)
+	}
+	recording.GatherSubFile(subFilePath)
+	recording.GatherNodes()
+
+	// Nothing replay needs may be left around but archive
+	server.Close()
+	os.Remove(subFilePath)
+	os.RemoveAll(FETCH_CACHE_DIRNAME)
+
+	replaying := MakeSubProvider()
+	if err := replaying.SetReplay(archiveDir); err != nil {
+		t.Fatal(err)
+	}
+	replaying.GatherSubFile(subFilePath)
+	replaying.GatherNodes()
+
+	if hits != 1 {
+		t.Errorf("server hit %d times, want once while recording", hits)
+	}
+	// Nodes are gathered concurrently, order says nothing
+	slices.Sort(recording.Nodes)
+	slices.Sort(replaying.Nodes)
+	if len(recording.Nodes) != 2 || !slices.Equal(recording.Nodes, replaying.Nodes) {
+		t.Errorf("replay got %v, recording got %v", replaying.Nodes, recording.Nodes)
+	}
+	if !replaying.IsReplay() || recording.IsReplay() {
+		t.Error("replay run not told apart from recording")
+	}
+	if replaying.userinfos[server.URL+"/sub.txt"] == nil {
+		t.Error("userinfo header not replayed")
+	}
+}
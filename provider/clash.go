@@ -0,0 +1,29 @@
+package provider
+
+import (
+	toolProvider "github.com/FoolVPN-ID/tool/modules/provider"
+	"gopkg.in/yaml.v3"
+)
+
+type clashConfigStruct struct {
+	Proxies []map[string]any `yaml:"proxies"`
+}
+
+func isClashBody(body string) bool {
+	var clashConfig = clashConfigStruct{}
+	if err := yaml.Unmarshal([]byte(body), &clashConfig); err != nil {
+		return false
+	}
+
+	return len(clashConfig.Proxies) > 0
+}
+
+func (prov *providerStruct) parseClashBody(body string) []string {
+	outbounds, err := toolProvider.Parse(body)
+	if err != nil {
+		prov.logger.Error(err.Error())
+		return []string{}
+	}
+
+	return outboundsToNodes(outbounds)
+}
@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+	toolProvider "github.com/FoolVPN-ID/tool/modules/provider"
+	singJson "github.com/sagernet/sing/common/json"
+)
+
+// Identity of raw node, parsed once per run as every node is looked up many times
+func (prov *providerStruct) getNodeId(node string) string {
+	prov.Lock()
+	nodeId, ok := prov.nodeIdCache[node]
+	prov.Unlock()
+
+	if ok {
+		return nodeId
+	}
+	nodeId = getNodeIdentity(node)
+
+	prov.Lock()
+	prov.nodeIdCache[node] = nodeId
+	prov.Unlock()
+
+	return nodeId
+}
+
+// Canonical identity of a node, regardless of remark, parameter order or url encoding
+func getNodeIdentity(node string) string {
+	outbounds, err := toolProvider.Parse(node)
+	if err != nil || len(outbounds) == 0 {
+		// Unparsable, at least ignore the remark
+		rawNode, _, _ := strings.Cut(strings.TrimSpace(node), "#")
+		return helper.GetMD5FromString(rawNode)
+	}
+
+	var identities = []string{}
+	for _, outbound := range outbounds {
+		var (
+			outboundMapping = map[string]any{}
+			outboundByte, _ = singJson.Marshal(outbound.Options)
+		)
+		json.Unmarshal(outboundByte, &outboundMapping)
+
+		// Detour names chained outbound by tag, tags come from remark, chain is kept by outbound order
+		delete(outboundMapping, "detour")
+
+		// Map keys are marshalled in sorted order
+		identityByte, _ := json.Marshal(map[string]any{
+			"type":    outbound.Type,
+			"options": outboundMapping,
+		})
+		identities = append(identities, string(identityByte))
+	}
+
+	return helper.GetMD5FromString(strings.Join(identities, "|"))
+}
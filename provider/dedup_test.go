@@ -0,0 +1,31 @@
+package provider
+
+import "testing"
+
+func TestNodeIdentity(t *testing.T) {
+	var (
+		prov   = MakeSubProvider()
+		node   = "trojan://pass@1.1.1.1:443?security=tls&sni=a.example.com&type=ws#first"
+		others = []string{
+			"trojan://pass@1.1.1.1:443?type=ws&sni=a.example.com&security=tls#renamed",
+			"trojan://pass@1.1.1.1:443?security=tls&sni=a.example.com&type=ws#%E2%9C%85",
+		}
+	)
+
+	nodeId := prov.getNodeId(node)
+	for _, other := range others {
+		if otherId := prov.getNodeId(other); otherId != nodeId {
+			t.Errorf("%s got identity %s, want %s", other, otherId, nodeId)
+		}
+	}
+
+	if differentId := prov.getNodeId("trojan://pass@1.0.0.1:443?security=tls&sni=a.example.com&type=ws#first"); differentId == nodeId {
+		t.Error("different server got the same identity")
+	}
+
+	// Later lookups are served from cache
+	prov.nodeIdCache[node] = "cached"
+	if prov.getNodeId(node) != "cached" {
+		t.Error("identity parsed again")
+	}
+}
@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	subUrlPattern    = regexp.MustCompile(`https?://[^\s"'<>|,\\]+`)
+	subUrlExtensions = []string{"", ".txt", ".yaml", ".yml", ".json", ".conf"}
+	subUrlKeywords   = []string{"sub", "clash", "v2ray", "vless", "vmess", "trojan", "node", "proxies", "token="}
+)
+
+// Follow nested sub lists and subscription urls found inside fetched body
+func (prov *providerStruct) discover(nodes chan<- NodeStruct, textBody, list string, depth int) {
+	if depth >= prov.maxDepth {
+		return
+	}
+
+	// Links hide inside base64 subscriptions as well, undecodable body is searched as is
+	textBody, _ = decodeBody(textBody)
+
+	// Sub list linking to other subscriptions
+	var subFile = []providerSubStruct{}
+	if err := json.Unmarshal([]byte(textBody), &subFile); err == nil {
+		prov.fetchSubList(nodes, subFile, list, depth+1)
+		return
+	}
+
+	// Plain links go through sub list fetching too, bounded like any other list
+	var subs = []providerSubStruct{}
+	for _, subUrl := range subUrlPattern.FindAllString(textBody, -1) {
+		if isSubUrl(subUrl) {
+			subs = append(subs, providerSubStruct{URL: subUrl})
+		}
+	}
+
+	prov.fetchSubList(nodes, subs, list, depth+1)
+}
+
+// Guess whether url points to subscription, not to random page or asset
+func isSubUrl(subUrl string) bool {
+	parsedUrl, err := url.Parse(subUrl)
+	if err != nil || parsedUrl.Host == "" {
+		return false
+	}
+
+	if !slices.Contains(subUrlExtensions, strings.ToLower(path.Ext(parsedUrl.Path))) {
+		return false
+	}
+
+	var lowerUrl = strings.ToLower(subUrl)
+	for _, keyword := range subUrlKeywords {
+		if strings.Contains(lowerUrl, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Mark url as visited, returns false if it was already
+func (prov *providerStruct) visit(subUrl string) bool {
+	prov.Lock()
+	defer prov.Unlock()
+
+	if prov.visitedUrls[subUrl] {
+		return false
+	}
+	prov.visitedUrls[subUrl] = true
+
+	return true
+}
+
+func (prov *providerStruct) markVisited(subUrl string) {
+	prov.visit(subUrl)
+}
+
+// Depth of nested subscriptions to follow, 0 disables discovery
+func (prov *providerStruct) SetMaxDepth(depth int) {
+	prov.maxDepth = depth
+}
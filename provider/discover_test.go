@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+func TestDiscoverFetchesOnce(t *testing.T) {
+	t.Chdir(t.TempDir())
+	helper.SetNetAllowlist([]string{"127.0.0.1"})
+	t.Cleanup(helper.ResetNetAllowlist)
+
+	var (
+		hits   = map[string]int{}
+		lock   = sync.Mutex{}
+		bodies = map[string]string{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.URL.Path]++
+		lock.Unlock()
+
+		w.Write([]byte(bodies[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	// Both lists and both subs point at each other
+	bodies["/list.json"] = fmt.Sprintf(`[{"url": "%[1]s/sub1.txt"}, {"url": "%[1]s/sub2.txt|%[1]s/sub1.txt"}, {"url": "%[1]s/list.json"}]`, server.URL)
+	bodies["/sub1.txt"] = strings.Join([]string{
+		"trojan://pass@1.1.1.1:443#first-node-with-a-long-enough-remark",
+		server.URL + "/sub2.txt",
+		server.URL + "/sub3.txt",
+		server.URL + "/sub3.txt",
+	}, "\n")
+	bodies["/sub2.txt"] = strings.Join([]string{
+		"trojan://pass@1.0.0.1:443#second-node-with-a-long-enough-remark",
+		server.URL + "/sub1.txt",
+		server.URL + "/sub3.txt",
+	}, "\n")
+	bodies["/sub3.txt"] = "trojan://pass@8.8.8.8:443#third-node-with-a-remark-long-enough-to-pass-the-minimum-body-size-check-of-discovery"
+
+	prov := MakeSubProvider()
+	prov.AddSource("aggregator", server.URL+"/list.json")
+	prov.GatherNodes()
+
+	for _, path := range []string{"/list.json", "/sub1.txt", "/sub2.txt", "/sub3.txt"} {
+		if hits[path] != 1 {
+			t.Errorf("%s fetched %d times, want once", path, hits[path])
+		}
+	}
+	if len(prov.Nodes) != 3 {
+		t.Errorf("got %d nodes, want 3", len(prov.Nodes))
+	}
+}
+
+func TestDiscoverInsideDecodedBody(t *testing.T) {
+	t.Chdir(t.TempDir())
+	helper.SetNetAllowlist([]string{"127.0.0.1"})
+	t.Cleanup(helper.ResetNetAllowlist)
+
+	var (
+		hits   = map[string]int{}
+		lock   = sync.Mutex{}
+		bodies = map[string]string{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.URL.Path]++
+		lock.Unlock()
+
+		w.Write([]byte(bodies[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	var nestedNode = "trojan://pass@8.8.8.8:443#nested-node-with-a-remark-long-enough-to-pass-the-minimum-body-size-check-of-discovery"
+	bodies["/base64.txt"] = base64.StdEncoding.EncodeToString([]byte("trojan://pass@1.1.1.1:443#first\n" + server.URL + "/nested-sub.txt"))
+	bodies["/clash.yaml"] = "# more at " + server.URL + "/clash-sub.txt\nproxies:\n  - {name: clash, type: trojan, server: 1.0.0.1, port: 443, password: pass}\n"
+	bodies["/nested-sub.txt"] = nestedNode
+	bodies["/clash-sub.txt"] = strings.Replace(nestedNode, "8.8.8.8", "8.8.4.4", 1)
+
+	prov := MakeSubProvider()
+	prov.AddSource("uri", server.URL+"/base64.txt")
+	prov.AddSource("clash", server.URL+"/clash.yaml")
+	prov.GatherNodes()
+
+	for _, path := range []string{"/nested-sub.txt", "/clash-sub.txt"} {
+		if hits[path] != 1 {
+			t.Errorf("%s fetched %d times, want once", path, hits[path])
+		}
+	}
+	if len(prov.Nodes) != 4 {
+		t.Errorf("got %d nodes, want 4", len(prov.Nodes))
+	}
+}
@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+	"github.com/FoolVPN-ID/megalodon/constant"
+)
+
+const extractMaxDepth = 2
+
+var (
+	nodeUriPattern, nodeStartPattern = makeNodeUriPatterns()
+
+	htmlTagPattern   = regexp.MustCompile(`<[A-Za-z/!]`)
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</?(?:p|div|li|pre|code|span|a|td|tr)\b[^>]*>`)
+	base64Pattern    = regexp.MustCompile(`[A-Za-z0-9+/_-]{32,}={0,2}`)
+)
+
+// Match any accepted scheme up to whitespace, quote, tag or list separator,
+// remark after # runs to the end of line as it may hold spaces
+func makeNodeUriPatterns() (*regexp.Regexp, *regexp.Regexp) {
+	var schemes = []string{}
+	for _, acceptedType := range constant.ACCEPTED_TYPES {
+		schemes = append(schemes, regexp.QuoteMeta(acceptedType))
+	}
+
+	// Longest first, so vless:// is never cut into ss://
+	slices.SortFunc(schemes, func(a, b string) int {
+		return len(b) - len(a)
+	})
+
+	var schemePattern = `(?:` + strings.Join(schemes, "|") + `)`
+	return regexp.MustCompile(schemePattern + `[^\s"'<>|#` + "`" + `]+(?:#[^\r\n"'<>|` + "`" + `]*)?`),
+		regexp.MustCompile(`[ \t]+(` + schemePattern + `)`)
+}
+
+// Scan any text, html included, for node uris
+func extractNodeURIs(textBody string) []string {
+	return extractNodeURIsWithDepth(textBody, 0)
+}
+
+func extractNodeURIsWithDepth(textBody string, depth int) []string {
+	// Only html is unescaped, once, plain text may hold query like &not=1 that unescaping mangles
+	if htmlTagPattern.MatchString(textBody) {
+		textBody = html.UnescapeString(htmlBreakPattern.ReplaceAllString(textBody, "\n"))
+	}
+	for _, acceptedType := range constant.ACCEPTED_TYPES {
+		textBody = strings.ReplaceAll(textBody, ","+acceptedType, "\n"+acceptedType)
+	}
+
+	// Remark runs to the end of line, next node on the same line would end up in it
+	textBody = nodeStartPattern.ReplaceAllString(textBody, "\n$1")
+
+	var uris = []string{}
+	for _, uri := range nodeUriPattern.FindAllString(textBody, -1) {
+		uri = trimProseTail(uri)
+
+		if !slices.Contains(constant.ACCEPTED_TYPES, uri) {
+			uris = append(uris, uri)
+		}
+	}
+
+	if depth >= extractMaxDepth {
+		return uris
+	}
+
+	// Base64 fragments left after taking uris out may hold more nodes
+	var leftover = nodeUriPattern.ReplaceAllString(textBody, "\n")
+	for _, fragment := range base64Pattern.FindAllString(leftover, -1) {
+		if decoded := helper.DecodeBase64Safe(fragment); decoded != fragment && strings.Contains(decoded, "://") {
+			uris = append(uris, extractNodeURIsWithDepth(decoded, depth+1)...)
+		}
+	}
+
+	return uris
+}
+
+// Drop what prose leaves after uri or its remark, closing bracket only if nothing in uri opened it
+func trimProseTail(uri string) string {
+	for {
+		trimmed := strings.TrimRight(uri, " \t.,;:!?")
+		for _, pair := range []string{"()", "[]", "{}"} {
+			if strings.HasSuffix(trimmed, pair[1:]) && strings.Count(trimmed, pair[1:]) > strings.Count(trimmed, pair[:1]) {
+				trimmed = strings.TrimSuffix(trimmed, pair[1:])
+			}
+		}
+
+		if trimmed == uri {
+			return uri
+		}
+		uri = trimmed
+	}
+}
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExtractNodeURIs(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			"remark with spaces",
+			"trojan://pass@1.1.1.1:443?sni=a.example.com#SG Node (Premium) \nvless://id@1.0.0.1:443#second node",
+			[]string{"trojan://pass@1.1.1.1:443?sni=a.example.com#SG Node (Premium)", "vless://id@1.0.0.1:443#second node"},
+		},
+		{
+			"nodes sharing a line",
+			"trojan://pass@1.1.1.1:443#first node trojan://pass@1.0.0.1:443#second,ss://YWVzLTEyOC1nY206cGFzcw@8.8.8.8:8388#third",
+			[]string{"trojan://pass@1.1.1.1:443#first node", "trojan://pass@1.0.0.1:443#second", "ss://YWVzLTEyOC1nY206cGFzcw@8.8.8.8:8388#third"},
+		},
+		{
+			"prose around bare uri",
+			"Try this (trojan://pass@1.1.1.1:443?sni=a.example.com).",
+			[]string{"trojan://pass@1.1.1.1:443?sni=a.example.com"},
+		},
+		{
+			"prose around remark",
+			"Nodes: trojan://pass@1.1.1.1:443#first node, trojan://pass@1.0.0.1:443#SG Node (Premium).\n(vless://id@8.8.8.8:443#[third])",
+			[]string{"trojan://pass@1.1.1.1:443#first node", "trojan://pass@1.0.0.1:443#SG Node (Premium)", "vless://id@8.8.8.8:443#[third]"},
+		},
+		{
+			"html unescaped once",
+			"<p>trojan://pass@1.1.1.1:443?sni=a.example.com&amp;type=ws&amp;amp;x=1#a &amp; b</p>",
+			[]string{"trojan://pass@1.1.1.1:443?sni=a.example.com&type=ws&amp;x=1#a & b"},
+		},
+		{
+			"plain text left as is",
+			"trojan://pass@1.1.1.1:443?sni=a.example.com&not=1&copy=2&reg=3#plain",
+			[]string{"trojan://pass@1.1.1.1:443?sni=a.example.com&not=1&copy=2&reg=3#plain"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := extractNodeURIs(test.body); !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+	fastshot "github.com/opus-domini/fast-shot"
+	"github.com/opus-domini/fast-shot/constant/header"
+)
+
+const FETCH_CACHE_DIRNAME = "fetch_cache"
+
+type fetchCacheStruct struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"last_modified"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	Size         int       `json:"size"`
+	Userinfo     string    `json:"userinfo,omitempty"`
+	Body         string    `json:"body"`
+}
+
+const (
+	fetchMaxAttempts = 4
+	fetchBaseBackoff = time.Second
+	fetchMaxBackoff  = 30 * time.Second
+)
+
+// Wait between retries, swapped by tests that shouldn't sleep through backoff
+var fetchSleep = time.Sleep
+
+type fetchErrorStruct struct {
+	URL        string
+	Status     int
+	Reason     string
+	Retryable  bool
+	RetryAfter time.Duration
+	Blocked    bool // Rejected by network policy, never worth going around
+}
+
+func (err *fetchErrorStruct) Error() string {
+	return fmt.Sprintf("%s: %s", err.Reason, err.URL)
+}
+
+// Fetch url directly, falling back to fetch proxies for blocked hosts.
+// Returns the path body came through, file:// urls are read straight from disk
+func (prov *providerStruct) fetch(rawUrl string) (string, string, error) {
+	if strings.HasPrefix(rawUrl, "file://") {
+		body, err := prov.readLocal(rawUrl, func() (string, error) {
+			return helper.ReadFileAsString(strings.TrimPrefix(rawUrl, "file://"))
+		})
+		return body, "file", err
+	}
+
+	if prov.replayDir != "" {
+		body, err := prov.replayArchive(rawUrl)
+		return body, "replay", err
+	}
+
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Sources are third party, don't let them point us at internal addresses
+	if err := helper.CheckPublicHost(parsedUrl.Hostname()); err != nil {
+		return "", "", &fetchErrorStruct{URL: rawUrl, Reason: err.Error(), Blocked: true}
+	}
+
+	var (
+		host      = parsedUrl.Hostname()
+		proxies   = prov.getFetchProxies()
+		lastErr   error
+		fetchErr  *fetchErrorStruct
+		isBlocked = prov.isDirectBlocked(host)
+	)
+
+	// Don't waste retries on hosts known to be unreachable, if there is another way
+	if !isBlocked || len(proxies) == 0 {
+		body, err := prov.fetchWithRetry(rawUrl, "")
+		if err == nil {
+			return body, "direct", nil
+		}
+		lastErr = err
+
+		if errors.As(err, &fetchErr) && fetchErr.Blocked {
+			return "", "", err
+		} else if errors.As(err, &fetchErr) && fetchErr.Status > 0 {
+			// Gone for everyone, going around won't help
+			if fetchErr.Status == 404 || fetchErr.Status == 410 {
+				return "", "", err
+			}
+		} else {
+			prov.markDirectBlocked(host)
+		}
+	}
+
+	for _, proxy := range proxies {
+		body, err := prov.fetchWithRetry(rawUrl, proxy.URL)
+		if err == nil {
+			return body, proxy.Name, nil
+		}
+		lastErr = err
+	}
+
+	return "", "", lastErr
+}
+
+// Retry with backoff on rate limit, server errors and timeouts
+func (prov *providerStruct) fetchWithRetry(rawUrl, proxyUrl string) (string, error) {
+	var lastErr error
+	for attempt := range fetchMaxAttempts {
+		body, err := prov.fetchOnce(rawUrl, proxyUrl)
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+
+		var fetchErr *fetchErrorStruct
+		if !errors.As(err, &fetchErr) || !fetchErr.Retryable || attempt == fetchMaxAttempts-1 {
+			break
+		}
+
+		fetchSleep(getFetchBackoff(attempt, fetchErr.RetryAfter))
+	}
+
+	return "", lastErr
+}
+
+// Single request, reusing cached body when upstream says it's not modified
+func (prov *providerStruct) fetchOnce(rawUrl, proxyUrl string) (string, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		release       = prov.getHostLimiter(parsedUrl.Host).wait()
+		cache         = loadFetchCache(rawUrl)
+		clientBuilder = fastshot.NewClient(rawUrl).
+				Config().SetTimeout(10 * time.Second)
+	)
+	defer release()
+
+	if proxyUrl != "" {
+		clientBuilder = clientBuilder.Config().SetProxy(proxyUrl)
+	} else if !helper.IsAllowedHost(parsedUrl.Hostname()) {
+		// Check again on connect, redirects and rebinding dns bypass the host check
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: helper.PublicOnlyControl,
+		}).DialContext
+		clientBuilder = clientBuilder.Config().SetCustomTransport(transport)
+	}
+	request := clientBuilder.Build().GET("")
+
+	if cache != nil {
+		if cache.ETag != "" {
+			request = request.Header().Set(header.IfNoneMatch, cache.ETag)
+		}
+		if cache.LastModified != "" {
+			request = request.Header().Set(header.IfModifiedSince, cache.LastModified)
+		}
+	}
+
+	resp, err := request.Send()
+	if err != nil {
+		if errors.Is(err, helper.ErrBlockedTarget) {
+			return "", &fetchErrorStruct{URL: rawUrl, Reason: helper.ErrBlockedTarget.Error(), Blocked: true}
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return "", &fetchErrorStruct{URL: rawUrl, Reason: "timeout", Retryable: true}
+		}
+		return "", &fetchErrorStruct{URL: rawUrl, Reason: err.Error()}
+	}
+	defer resp.Body().Close()
+
+	switch statusCode := resp.Status().Code(); {
+	case statusCode == 304:
+		if cache == nil {
+			return "", &fetchErrorStruct{URL: rawUrl, Status: statusCode, Reason: "not modified without cache"}
+		}
+
+		cache.FetchedAt = time.Now()
+		saveFetchCache(cache)
+		prov.setUserinfo(rawUrl, cache.Userinfo)
+
+		headers := resp.Raw().Header.Clone()
+		if headers.Get("Subscription-Userinfo") == "" && cache.Userinfo != "" {
+			headers.Set("Subscription-Userinfo", cache.Userinfo)
+		}
+		prov.recordArchive(rawUrl, 200, headers, cache.Body)
+
+		return cache.Body, nil
+	case statusCode == 200:
+		body, err := resp.Body().AsString()
+		if err != nil {
+			return "", &fetchErrorStruct{URL: rawUrl, Reason: err.Error(), Retryable: true}
+		}
+
+		userinfo := resp.Header().Get("Subscription-Userinfo")
+		saveFetchCache(&fetchCacheStruct{
+			URL:          rawUrl,
+			ETag:         resp.Header().Get(string(header.ETag)),
+			LastModified: resp.Header().Get(string(header.LastModified)),
+			FetchedAt:    time.Now(),
+			Size:         len(body),
+			Userinfo:     userinfo,
+			Body:         body,
+		})
+		prov.setUserinfo(rawUrl, userinfo)
+		prov.recordArchive(rawUrl, statusCode, resp.Raw().Header, body)
+
+		return body, nil
+	case statusCode == 429:
+		retryAfter, _ := strconv.Atoi(resp.Header().Get(string(header.RetryAfter)))
+		return "", &fetchErrorStruct{URL: rawUrl, Status: statusCode, Reason: "rate limited", Retryable: true, RetryAfter: time.Duration(retryAfter) * time.Second}
+	case statusCode == 404 || statusCode == 410:
+		prov.recordArchive(rawUrl, statusCode, resp.Raw().Header, "")
+		return "", &fetchErrorStruct{URL: rawUrl, Status: statusCode, Reason: fmt.Sprintf("unexpected status %d", statusCode)}
+	case statusCode >= 500:
+		return "", &fetchErrorStruct{URL: rawUrl, Status: statusCode, Reason: fmt.Sprintf("server error %d", statusCode), Retryable: true}
+	default:
+		return "", &fetchErrorStruct{URL: rawUrl, Status: statusCode, Reason: fmt.Sprintf("unexpected status %d", statusCode)}
+	}
+}
+
+func getFetchBackoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, fetchMaxBackoff)
+	}
+
+	backoff := fetchBaseBackoff << attempt
+	backoff += time.Duration(rand.Int64N(int64(backoff) / 2))
+
+	return min(backoff, fetchMaxBackoff)
+}
+
+func getFetchCachePath(url string) string {
+	return filepath.Join(FETCH_CACHE_DIRNAME, helper.GetMD5FromString(url)+".json")
+}
+
+func loadFetchCache(url string) *fetchCacheStruct {
+	cacheByte, err := os.ReadFile(getFetchCachePath(url))
+	if err != nil {
+		return nil
+	}
+
+	cache := fetchCacheStruct{}
+	if err := json.Unmarshal(cacheByte, &cache); err != nil || cache.URL != url {
+		return nil
+	}
+
+	return &cache
+}
+
+func saveFetchCache(cache *fetchCacheStruct) {
+	if err := os.MkdirAll(FETCH_CACHE_DIRNAME, 0755); err != nil {
+		return
+	}
+
+	cacheByte, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+
+	os.WriteFile(getFetchCachePath(cache.URL), cacheByte, 0644)
+}
@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+	"github.com/FoolVPN-ID/megalodon/common/shared"
+)
+
+func TestFetchOnceNotModified(t *testing.T) {
+	t.Chdir(t.TempDir())
+	helper.SetNetAllowlist([]string{"127.0.0.1"})
+	t.Cleanup(helper.ResetNetAllowlist)
+
+	const (
+		etag         = `"v1"`
+		lastModified = "Mon, 10 Mar 2025 00:00:00 GMT"
+		body         = "trojan://pass@1.1.1.1:443#cached-node"
+	)
+
+	var requests = []http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Clone())
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("Subscription-Userinfo", "upload=0; download=0; total=1")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	var (
+		prov   = MakeSubProvider()
+		subUrl = server.URL + "/sub.txt"
+	)
+	for range 2 {
+		if got, err := prov.fetchOnce(subUrl, ""); err != nil || got != body {
+			t.Fatalf("got %q, %v, want cached body", got, err)
+		}
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	if requests[0].Get("If-None-Match") != "" || requests[0].Get("If-Modified-Since") != "" {
+		t.Error("first fetch is conditional without cache")
+	}
+	if requests[1].Get("If-None-Match") != etag || requests[1].Get("If-Modified-Since") != lastModified {
+		t.Errorf("second fetch sent %q and %q, want validators of cache", requests[1].Get("If-None-Match"), requests[1].Get("If-Modified-Since"))
+	}
+
+	// Quota header is only sent with full body, 304 takes it from cache
+	prov.userinfos = map[string]*shared.SubUserinfoStruct{}
+	if _, err := prov.fetchOnce(subUrl, ""); err != nil || prov.userinfos[subUrl] == nil {
+		t.Errorf("userinfo not restored from cache: %v", err)
+	}
+}
+
+// Answer with given statuses in order, last one repeats
+func makeStatusSequenceServer(statuses []int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	var hits = &atomic.Int32{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[min(int(hits.Add(1)), len(statuses))-1]
+		if status == http.StatusTooManyRequests && retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("trojan://pass@1.1.1.1:443#retried-node"))
+	})), hits
+}
+
+// Record backoffs instead of sleeping through them
+func recordFetchSleeps(t *testing.T) *[]time.Duration {
+	var sleeps = &[]time.Duration{}
+	fetchSleep = func(backoff time.Duration) {
+		*sleeps = append(*sleeps, backoff)
+	}
+	hostMinInterval = 0
+	t.Cleanup(func() {
+		fetchSleep = time.Sleep
+		hostMinInterval = 250 * time.Millisecond
+	})
+
+	return sleeps
+}
+
+func TestFetchWithRetry(t *testing.T) {
+	t.Chdir(t.TempDir())
+	helper.SetNetAllowlist([]string{"127.0.0.1"})
+	t.Cleanup(helper.ResetNetAllowlist)
+
+	for _, test := range []struct {
+		name       string
+		statuses   []int
+		retryAfter string
+		wantErr    string
+		wantSleeps []time.Duration // Jitter adds up to half, only lower bounds are checked
+	}{
+		{
+			name:       "rate limited honors retry after",
+			statuses:   []int{429, 200},
+			retryAfter: "7",
+			wantSleeps: []time.Duration{7 * time.Second},
+		},
+		{
+			name:       "retry after capped",
+			statuses:   []int{429, 200},
+			retryAfter: "120",
+			wantSleeps: []time.Duration{fetchMaxBackoff},
+		},
+		{
+			name:       "server errors back off",
+			statuses:   []int{503, 502, 200},
+			wantSleeps: []time.Duration{fetchBaseBackoff, 2 * fetchBaseBackoff},
+		},
+		{
+			name:       "gives up after max attempts",
+			statuses:   []int{500},
+			wantErr:    "server error 500",
+			wantSleeps: []time.Duration{fetchBaseBackoff, 2 * fetchBaseBackoff, 4 * fetchBaseBackoff},
+		},
+		{
+			name:     "client error not retried",
+			statuses: []int{403},
+			wantErr:  "unexpected status 403",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				sleeps       = recordFetchSleeps(t)
+				server, hits = makeStatusSequenceServer(test.statuses, test.retryAfter)
+			)
+			defer server.Close()
+
+			_, err := MakeSubProvider().fetchWithRetry(server.URL+"/sub.txt", "")
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("got %v, want body", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("got %v, want %q", err, test.wantErr)
+			}
+
+			if int(hits.Load()) != len(test.wantSleeps)+1 {
+				t.Errorf("got %d attempts, want %d", hits.Load(), len(test.wantSleeps)+1)
+			}
+			if len(*sleeps) != len(test.wantSleeps) {
+				t.Fatalf("slept %v, want %v", *sleeps, test.wantSleeps)
+			}
+			for i, sleep := range *sleeps {
+				if sleep < test.wantSleeps[i] || sleep > test.wantSleeps[i]*3/2 {
+					t.Errorf("backoff %d is %v, want %v plus jitter", i, sleep, test.wantSleeps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFetchGiveUpRecordsReason(t *testing.T) {
+	t.Chdir(t.TempDir())
+	helper.SetNetAllowlist([]string{"127.0.0.1"})
+	t.Cleanup(helper.ResetNetAllowlist)
+	recordFetchSleeps(t)
+
+	server, _ := makeStatusSequenceServer([]int{503}, "")
+	defer server.Close()
+
+	var (
+		prov      = MakeSubProvider()
+		sourceUrl = server.URL + "/sub.txt"
+	)
+	prov.AddSource("uri", sourceUrl)
+	prov.GatherNodes()
+
+	if !prov.HasFailedSources() {
+		t.Error("source not kept for retry")
+	}
+	if reason := prov.runStats[sourceUrl].Error; !strings.Contains(reason, "server error 503") {
+		t.Errorf("recorded %q, want server error", reason)
+	}
+}
+
+func TestHostConcurrencyLimit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	helper.SetNetAllowlist([]string{"127.0.0.1"})
+	t.Cleanup(helper.ResetNetAllowlist)
+	recordFetchSleeps(t)
+
+	var current, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight := current.Add(1)
+		defer current.Add(-1)
+
+		for {
+			if seen := peak.Load(); inFlight <= seen || peak.CompareAndSwap(seen, inFlight) {
+				break
+			}
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("trojan://pass@1.1.1.1:443#limited-node"))
+	}))
+	defer server.Close()
+
+	var (
+		prov = MakeSubProvider()
+		wg   = sync.WaitGroup{}
+	)
+	for i := range 3 * hostMaxConcurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := prov.fetchOnce(fmt.Sprintf("%s/sub%d.txt", server.URL, i), ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak.Load() != hostMaxConcurrency {
+		t.Errorf("host had %d requests in flight, want %d", peak.Load(), hostMaxConcurrency)
+	}
+}
@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+var localNodeExtensions = []string{".txt", ".yaml", ".yml", ".json"}
+
+func init() {
+	registerSource("file", func(prov *providerStruct, target string) Source {
+		return &fileSource{prov: prov, path: strings.TrimPrefix(target, "file://")}
+	})
+	registerSource("dir", func(prov *providerStruct, target string) Source {
+		return &dirSource{prov: prov, dir: target, seen: map[string]dumpStateStruct{}}
+	})
+	registerSource("stdin", func(prov *providerStruct, target string) Source {
+		return &stdinSource{prov: prov}
+	})
+}
+
+// Single node dump on disk
+type fileSource struct {
+	prov *providerStruct
+	path string
+}
+
+func (source *fileSource) Fetch(nodes chan<- NodeStruct) error {
+	source.prov.recordFetch("local", source.path, 0)
+
+	textBody, err := source.prov.readLocal("file://"+source.path, func() (string, error) {
+		return helper.ReadFileAsString(source.path)
+	})
+	if err != nil {
+		source.prov.recordFailure("local", source.path, err)
+		return err
+	}
+
+	source.prov.emitBody(nodes, textBody, source.path, "local", nil, source.prov.parseBody)
+	return nil
+}
+
+// Hand collected dumps dropped into dir, re-read on each poll
+type dirSource struct {
+	prov *providerStruct
+	dir  string
+	seen map[string]dumpStateStruct
+}
+
+// Dump is read again only once it changes
+type dumpStateStruct struct {
+	modTime time.Time
+	size    int64
+}
+
+func (source *dirSource) Fetch(nodes chan<- NodeStruct) error {
+	listing, err := source.prov.readLocal("dir://"+source.dir, source.listDumps)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(listing, "\n") {
+		if name == "" {
+			continue
+		}
+
+		var (
+			path  = filepath.Join(source.dir, name)
+			state = dumpStateStruct{}
+		)
+
+		// Replayed dumps have no state, they are read once
+		if info, err := os.Stat(path); err == nil {
+			state = dumpStateStruct{modTime: info.ModTime(), size: info.Size()}
+		}
+		if seenState, ok := source.seen[path]; ok && seenState == state {
+			continue
+		}
+		source.seen[path] = state
+
+		fileSource := fileSource{
+			prov: source.prov,
+			path: path,
+		}
+		if err := fileSource.Fetch(nodes); err != nil {
+			source.prov.logger.Error(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Names of node dumps in dir, one per line
+func (source *dirSource) listDumps() (string, error) {
+	entries, err := os.ReadDir(source.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	var names = []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !slices.Contains(localNodeExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+
+	return strings.Join(names, "\n"), nil
+}
+
+// Re-read dump directories, returns nodes not seen before
+func (prov *providerStruct) PollDirs() []string {
+	var dirSources = []Source{}
+	for _, source := range prov.sources {
+		if _, ok := source.(*dirSource); ok {
+			dirSources = append(dirSources, source)
+		}
+	}
+
+	prov.Lock()
+	nodesCount := len(prov.Nodes)
+	prov.Unlock()
+
+	prov.gather(dirSources)
+
+	prov.Lock()
+	defer prov.Unlock()
+
+	return slices.Clone(prov.Nodes[nodesCount:])
+}
+
+// Nodes piped from other pipelines
+type stdinSource struct {
+	prov *providerStruct
+}
+
+func (source *stdinSource) Fetch(nodes chan<- NodeStruct) error {
+	source.prov.recordFetch("local", "stdin", 0)
+
+	textBody, err := source.prov.readLocal("stdin://", func() (string, error) {
+		textBody, err := io.ReadAll(os.Stdin)
+		return string(textBody), err
+	})
+	if err != nil {
+		return err
+	}
+
+	source.prov.emitBody(nodes, textBody, "stdin", "local", nil, source.prov.parseBody)
+	return nil
+}
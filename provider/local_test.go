@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPollDirs(t *testing.T) {
+	var dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "first.txt"), []byte("trojan://pass@1.1.1.1:443#first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	prov := MakeSubProvider()
+	prov.AddSource("dir", dir)
+	prov.GatherNodes()
+	if len(prov.Nodes) != 1 {
+		t.Fatalf("got %d nodes, want 1", len(prov.Nodes))
+	}
+
+	if newNodes := prov.PollDirs(); len(newNodes) != 0 {
+		t.Fatalf("unchanged dir yielded %v", newNodes)
+	}
+
+	// Dump dropped while running, along with a known node
+	if err := os.WriteFile(filepath.Join(dir, "second.txt"), []byte("trojan://pass@1.1.1.1:443#first\ntrojan://pass@1.0.0.1:443#second\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newNodes := prov.PollDirs()
+	if len(newNodes) != 1 || !slices.Contains(prov.Nodes, newNodes[0]) {
+		t.Fatalf("got %v, want the second node only", newNodes)
+	}
+	if len(prov.Nodes) != 2 {
+		t.Errorf("got %d nodes, want 2", len(prov.Nodes))
+	}
+}
+
+func TestReplayLocalSources(t *testing.T) {
+	var (
+		archiveDir = t.TempDir()
+		dumpDir    = t.TempDir()
+		dumpFile   = filepath.Join(t.TempDir(), "nodes.txt")
+	)
+	os.WriteFile(filepath.Join(dumpDir, "dump one.txt"), []byte("trojan://pass@1.1.1.1:443#first\n"), 0644)
+	os.WriteFile(dumpFile, []byte("trojan://pass@1.0.0.1:443#second\n"), 0644)
+
+	recorder := MakeSubProvider()
+	if err := recorder.SetRecord(archiveDir); err != nil {
+		t.Fatal(err)
+	}
+	recorder.AddSource("dir", dumpDir)
+	recorder.AddSource("file", "file://"+dumpFile)
+	recorder.GatherNodes()
+
+	// Replay must not see what changed on disk since recording
+	os.Remove(filepath.Join(dumpDir, "dump one.txt"))
+	os.WriteFile(filepath.Join(dumpDir, "later.txt"), []byte("trojan://pass@8.8.8.8:443#later\n"), 0644)
+	os.WriteFile(dumpFile, []byte("trojan://pass@8.8.4.4:443#changed\n"), 0644)
+
+	replayer := MakeSubProvider()
+	if err := replayer.SetReplay(archiveDir); err != nil {
+		t.Fatal(err)
+	}
+	replayer.AddSource("dir", dumpDir)
+	replayer.AddSource("file", "file://"+dumpFile)
+	replayer.GatherNodes()
+
+	slices.Sort(recorder.Nodes)
+	slices.Sort(replayer.Nodes)
+	if len(recorder.Nodes) != 2 || !slices.Equal(replayer.Nodes, recorder.Nodes) {
+		t.Fatalf("replayed %v, recorded %v", replayer.Nodes, recorder.Nodes)
+	}
+	if newNodes := replayer.PollDirs(); len(newNodes) != 0 {
+		t.Errorf("replay poll yielded %v", newNodes)
+	}
+}
@@ -2,20 +2,53 @@ package provider
 
 import (
 	"sync"
+	"time"
 
+	"github.com/FoolVPN-ID/megalodon/common/shared"
 	logger "github.com/FoolVPN-ID/megalodon/log"
 )
 
 type providerStruct struct {
-	subs   []providerSubStruct
-	Nodes  []string
-	logger logger.LoggerStruct
+	sources       []Source
+	Nodes         []string
+	logger        logger.LoggerStruct
+	nodeIds       map[string]bool
+	nodeIdCache   map[string]string
+	nodeSources   map[string][]string
+	nodeUserinfos map[string]*shared.SubUserinfoStruct
+	nodePassed    map[string][]string // Modes each node passed, by node id
+	userinfos     map[string]*shared.SubUserinfoStruct
+	sourceLists   map[string]string
+	stats         map[string]*sourceStatStruct
+	runStats      map[string]*sourceRunStruct
+	hostLimiters  map[string]*hostLimiterStruct
+	fetchProxies  []fetchProxyStruct
+	directBlocked map[string]bool
+	failedSources []Source
+	visitedUrls   map[string]bool
+	maxDepth      int
+	recordDir     string
+	replayDir     string
+	replayAt      time.Time
 	sync.Mutex
 }
 
 func MakeSubProvider() *providerStruct {
 	prov := providerStruct{
-		logger: *logger.MakeLogger(),
+		logger:        *logger.MakeLogger(),
+		nodeIds:       map[string]bool{},
+		nodeIdCache:   map[string]string{},
+		nodeSources:   map[string][]string{},
+		nodeUserinfos: map[string]*shared.SubUserinfoStruct{},
+		nodePassed:    map[string][]string{},
+		userinfos:     map[string]*shared.SubUserinfoStruct{},
+		sourceLists:   map[string]string{},
+		stats:         map[string]*sourceStatStruct{},
+		runStats:      map[string]*sourceRunStruct{},
+		hostLimiters:  map[string]*hostLimiterStruct{},
+		directBlocked: map[string]bool{},
+		visitedUrls:   map[string]bool{},
+		maxDepth:      1,
 	}
 
 	return &prov
@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"slices"
+
+	"github.com/FoolVPN-ID/megalodon/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json"
+)
+
+// Convert parsed outbounds into single-node sing-box configs, keeping detour chains together
+func outboundsToNodes(outbounds []option.Outbound) []string {
+	var (
+		nodes     = []string{}
+		tagged    = map[string]option.Outbound{}
+		detourTag = map[string]bool{}
+	)
+
+	for _, outbound := range outbounds {
+		if outbound.Tag != "" {
+			tagged[outbound.Tag] = outbound
+		}
+		if detour := getOutboundDetour(outbound); detour != "" {
+			detourTag[detour] = true
+		}
+	}
+
+	for _, outbound := range outbounds {
+		if detourTag[outbound.Tag] || !slices.Contains(constant.ACCEPTED_OUTBOUND_TYPES, outbound.Type) {
+			continue
+		}
+
+		nodeOutbounds := []option.Outbound{outbound}
+		if detour := getOutboundDetour(outbound); detour != "" {
+			detourOutbound, ok := tagged[detour]
+			if !ok {
+				continue
+			}
+			nodeOutbounds = append(nodeOutbounds, detourOutbound)
+		}
+
+		nodeByte, err := json.Marshal(map[string]any{
+			"outbounds": nodeOutbounds,
+		})
+		if err != nil {
+			continue
+		}
+
+		nodes = append(nodes, string(nodeByte))
+	}
+
+	return nodes
+}
+
+func getOutboundDetour(outbound option.Outbound) string {
+	var (
+		dialerOptions = struct {
+			Detour string `json:"detour"`
+		}{}
+		outboundByte, err = json.Marshal(outbound.Options)
+	)
+
+	if err != nil {
+		return ""
+	}
+	json.Unmarshal(outboundByte, &dialerOptions)
+
+	return dialerOptions.Detour
+}
@@ -0,0 +1,74 @@
+package provider
+
+import "slices"
+
+// Proxy used to fetch subscriptions when direct connection fails
+type fetchProxyStruct struct {
+	Name string
+	URL  string
+}
+
+// Add proxy tried in order after direct fetch fails, eg. socks5://127.0.0.1:1080
+func (prov *providerStruct) AddFetchProxy(name, proxyUrl string) {
+	prov.Lock()
+	defer prov.Unlock()
+
+	prov.fetchProxies = append(prov.fetchProxies, fetchProxyStruct{
+		Name: name,
+		URL:  proxyUrl,
+	})
+}
+
+func (prov *providerStruct) HasFailedSources() bool {
+	prov.Lock()
+	defer prov.Unlock()
+
+	return len(prov.failedSources) > 0
+}
+
+// Gather failed sources once more, through fetch proxies added since.
+// Returns nodes found by the retry
+func (prov *providerStruct) RetryFailedSources() []string {
+	prov.Lock()
+	var (
+		sources    = prov.failedSources
+		nodesCount = len(prov.Nodes)
+	)
+	prov.failedSources = nil
+	prov.Unlock()
+
+	prov.gather(sources)
+
+	prov.Lock()
+	defer prov.Unlock()
+
+	return slices.Clone(prov.Nodes[nodesCount:])
+}
+
+func (prov *providerStruct) addFailedSource(source Source) {
+	prov.Lock()
+	defer prov.Unlock()
+
+	prov.failedSources = append(prov.failedSources, source)
+}
+
+func (prov *providerStruct) getFetchProxies() []fetchProxyStruct {
+	prov.Lock()
+	defer prov.Unlock()
+
+	return append([]fetchProxyStruct{}, prov.fetchProxies...)
+}
+
+func (prov *providerStruct) isDirectBlocked(host string) bool {
+	prov.Lock()
+	defer prov.Unlock()
+
+	return prov.directBlocked[host]
+}
+
+func (prov *providerStruct) markDirectBlocked(host string) {
+	prov.Lock()
+	defer prov.Unlock()
+
+	prov.directBlocked[host] = true
+}
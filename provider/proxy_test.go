@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+func TestRetryFailedSourcesThroughProxy(t *testing.T) {
+	t.Chdir(t.TempDir())
+	helper.SetNetAllowlist([]string{"127.0.0.1"})
+	t.Cleanup(helper.ResetNetAllowlist)
+
+	// Same server plays the source and the proxy, only proxied requests carry absolute url
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.RequestURI, "http://") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Write([]byte(strings.Join([]string{
+			"trojan://pass@1.1.1.1:443#first-node-behind-proxy",
+			"trojan://pass@1.0.0.1:443#second-node-behind-proxy",
+		}, "\n")))
+	}))
+	defer server.Close()
+
+	var sourceUrl = server.URL + "/sub.txt"
+
+	prov := MakeSubProvider()
+	prov.AddSource("uri", sourceUrl)
+	prov.GatherNodes()
+
+	if len(prov.Nodes) != 0 || !prov.HasFailedSources() {
+		t.Fatalf("direct fetch got %d nodes, want source failed", len(prov.Nodes))
+	}
+
+	prov.AddFetchProxy("test-proxy", server.URL)
+	nodes := prov.RetryFailedSources()
+
+	if len(nodes) != 2 {
+		t.Errorf("retry got %d nodes, want 2", len(nodes))
+	}
+	if prov.HasFailedSources() {
+		t.Error("source still failed after retry")
+	}
+	if path := prov.runStats[sourceUrl].Path; path != "test-proxy" {
+		t.Errorf("recorded path %q, want test-proxy", path)
+	}
+}
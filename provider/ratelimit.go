@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"sync"
+	"time"
+)
+
+const hostMaxConcurrency = 4
+
+// Spacing between requests to a host, a var so tests can go at full speed
+var hostMinInterval = 250 * time.Millisecond
+
+// Limit concurrency and request rate of a single host
+type hostLimiterStruct struct {
+	queue chan struct{}
+	next  time.Time
+	sync.Mutex
+}
+
+// Block until request to host is allowed, returns release function
+func (limiter *hostLimiterStruct) wait() func() {
+	limiter.queue <- struct{}{}
+
+	limiter.Lock()
+	var delay = max(time.Until(limiter.next), 0)
+	limiter.next = time.Now().Add(delay + hostMinInterval)
+	limiter.Unlock()
+
+	time.Sleep(delay)
+
+	return func() {
+		<-limiter.queue
+	}
+}
+
+func (prov *providerStruct) getHostLimiter(host string) *hostLimiterStruct {
+	prov.Lock()
+	defer prov.Unlock()
+
+	limiter, ok := prov.hostLimiters[host]
+	if !ok {
+		limiter = &hostLimiterStruct{
+			queue: make(chan struct{}, hostMaxConcurrency),
+		}
+		prov.hostLimiters[host] = limiter
+	}
+
+	return limiter
+}
@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	box "github.com/sagernet/sing-box"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json"
+)
+
+type singboxConfigStruct struct {
+	Outbounds []json.RawMessage `json:"outbounds"`
+}
+
+type sip008ConfigStruct struct {
+	Version int                  `json:"version"`
+	Servers []sip008ServerStruct `json:"servers"`
+}
+
+type sip008ServerStruct struct {
+	ID         string `json:"id"`
+	Remarks    string `json:"remarks"`
+	Server     string `json:"server"`
+	ServerPort uint16 `json:"server_port"`
+	Password   string `json:"password"`
+	Method     string `json:"method"`
+	Plugin     string `json:"plugin"`
+	PluginOpts string `json:"plugin_opts"`
+}
+
+func isSingboxBody(body string) bool {
+	var singboxConfig = singboxConfigStruct{}
+	if err := json.Unmarshal([]byte(body), &singboxConfig); err != nil {
+		return false
+	}
+
+	return len(singboxConfig.Outbounds) > 0
+}
+
+func isSIP008Body(body string) bool {
+	var sip008Config = sip008ConfigStruct{}
+	if err := json.Unmarshal([]byte(body), &sip008Config); err != nil {
+		return false
+	}
+
+	return len(sip008Config.Servers) > 0
+}
+
+func (prov *providerStruct) parseSingboxBody(body string) []string {
+	var (
+		singboxConfig = singboxConfigStruct{}
+		outbounds     = []option.Outbound{}
+	)
+
+	if err := json.Unmarshal([]byte(body), &singboxConfig); err != nil {
+		prov.logger.Error(err.Error())
+		return []string{}
+	}
+
+	ctx := context.Background()
+	ctx = box.Context(ctx, include.InboundRegistry(), include.OutboundRegistry(), include.EndpointRegistry(), include.DNSTransportRegistry(), include.ServiceRegistry())
+
+	// Decode each outbound on its own, so one unsupported entry won't drop the whole config
+	for _, rawOutbound := range singboxConfig.Outbounds {
+		outbound := option.Outbound{}
+		if err := outbound.UnmarshalJSONContext(ctx, rawOutbound); err != nil {
+			continue
+		}
+
+		outbounds = append(outbounds, outbound)
+	}
+
+	return outboundsToNodes(outbounds)
+}
+
+func (prov *providerStruct) parseSIP008Body(body string) []string {
+	var (
+		sip008Config = sip008ConfigStruct{}
+		outbounds    = []option.Outbound{}
+	)
+
+	if err := json.Unmarshal([]byte(body), &sip008Config); err != nil {
+		prov.logger.Error(err.Error())
+		return []string{}
+	}
+
+	for i, server := range sip008Config.Servers {
+		var (
+			tag    = server.Remarks
+			plugin = server.Plugin
+		)
+
+		if tag == "" {
+			tag = fmt.Sprintf("sip008-%d", i)
+		}
+
+		// sing-box only knows simple-obfs by its client binary name
+		if plugin == "simple-obfs" {
+			plugin = "obfs-local"
+		}
+
+		outbounds = append(outbounds, option.Outbound{
+			Type: C.TypeShadowsocks,
+			Tag:  tag,
+			Options: &option.ShadowsocksOutboundOptions{
+				ServerOptions: option.ServerOptions{
+					Server:     server.Server,
+					ServerPort: server.ServerPort,
+				},
+				Method:        server.Method,
+				Password:      server.Password,
+				Plugin:        plugin,
+				PluginOptions: server.PluginOpts,
+			},
+		})
+	}
+
+	return outboundsToNodes(outbounds)
+}
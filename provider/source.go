@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/FoolVPN-ID/megalodon/common/shared"
+)
+
+// Node along with where it was found
+type NodeStruct struct {
+	Raw    string
+	Source string // Url or path the node was fetched from
+	List   string // Sub list the source belongs to
+
+	// Quota of the subscription, nil if not advertised
+	Userinfo *shared.SubUserinfoStruct
+}
+
+// Source fetches nodes and streams them into the channel, returning once exhausted
+type Source interface {
+	Fetch(nodes chan<- NodeStruct) error
+}
+
+type sourceFactory func(prov *providerStruct, target string) Source
+
+// Turns fetched body into raw nodes
+type bodyParser func(textBody string) []string
+
+var sourceRegistry = map[string]sourceFactory{}
+
+func registerSource(kind string, factory sourceFactory) {
+	sourceRegistry[kind] = factory
+}
+
+// Entry of sub list file, either plain url (aggregator list) or {"type": kind, "url": target}
+type sourceEntryStruct struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+func (entry *sourceEntryStruct) UnmarshalJSON(content []byte) error {
+	var entryUrl string
+	if err := json.Unmarshal(content, &entryUrl); err == nil {
+		entry.Type = "aggregator"
+		entry.URL = entryUrl
+		return nil
+	}
+
+	type entryAlias sourceEntryStruct
+	return json.Unmarshal(content, (*entryAlias)(entry))
+}
+
+func (prov *providerStruct) MakeSource(kind, target string) (Source, error) {
+	factory, ok := sourceRegistry[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown source type: %s", kind)
+	}
+
+	return factory(prov, target), nil
+}
+
+func (prov *providerStruct) AddSource(kind, target string) {
+	source, err := prov.MakeSource(kind, target)
+	if err != nil {
+		prov.logger.Error(err.Error())
+		return
+	}
+
+	prov.sources = append(prov.sources, source)
+}
+
+// Fetch every registered source and collect their nodes
+func (prov *providerStruct) GatherNodes() {
+	prov.gather(prov.sources)
+}
+
+func (prov *providerStruct) gather(sources []Source) {
+	var (
+		wg    = sync.WaitGroup{}
+		nodes = make(chan NodeStruct, 100)
+	)
+
+	// Parsing node identity is expensive, spread it
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for node := range nodes {
+				prov.addNode(node.Raw, node.Source, node.Userinfo)
+				prov.recordFetch(node.List, node.Source, 1)
+			}
+		}()
+	}
+
+	for _, source := range sources {
+		if err := source.Fetch(nodes); err != nil {
+			prov.logger.Error(err.Error())
+		}
+	}
+	close(nodes)
+
+	// Wait for all goroutines
+	wg.Wait()
+}
+
+// Parse body and stream every node found with its provenance
+func (prov *providerStruct) emitBody(nodes chan<- NodeStruct, textBody, source, list string, userinfo *shared.SubUserinfoStruct, parse bodyParser) {
+	var parsedNodes = parse(textBody)
+	for _, node := range parsedNodes {
+		nodes <- NodeStruct{
+			Raw:      node,
+			Source:   source,
+			List:     list,
+			Userinfo: userinfo,
+		}
+	}
+
+	prov.logger.Info(fmt.Sprintf("[%s] [%d] %s", list, len(parsedNodes), strings.TrimSpace(source)))
+}
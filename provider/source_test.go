@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSourceFixtures(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		kind   string
+		target string
+		want   int
+	}{
+		{"clash", "clash", "file://testdata/clash.yaml", 2},
+		{"singbox", "uri", "file://testdata/singbox.json", 2},
+		{"sip008", "uri", "file://testdata/sip008.json", 2},
+		{"html", "uri", "file://testdata/page.html", 4},
+		{"file", "file", "file://testdata/dir/nodes.txt", 2},
+		{"dir", "dir", "testdata/dir", 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			prov := MakeSubProvider()
+			source, err := prov.MakeSource(test.kind, test.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prov.gather([]Source{source})
+
+			if len(prov.Nodes) != test.want {
+				t.Fatalf("got %d nodes, want %d: %v", len(prov.Nodes), test.want, prov.Nodes)
+			}
+
+			// Every node has to be credited to where it came from
+			for _, node := range prov.Nodes {
+				sources := prov.nodeSources[prov.getNodeId(node)]
+				if len(sources) == 0 || !strings.Contains(sources[0], "testdata") {
+					t.Errorf("node %s credited to %v", node, sources)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownSourceKind(t *testing.T) {
+	if _, err := MakeSubProvider().MakeSource("ftp", "ftp://example.com"); err == nil {
+		t.Fatal("expected error for unknown source kind")
+	}
+}
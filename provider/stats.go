@@ -0,0 +1,324 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+const SOURCE_STATS_FILENAME = "source_stats.json"
+
+type sourceRunStruct struct {
+	Fetched int            `json:"fetched"`
+	Unique  int            `json:"unique"`
+	Alive   int            `json:"alive"`
+	Passed  map[string]int `json:"passed"`
+	Failed  map[string]int `json:"failed,omitempty"` // Dead nodes by failure reason
+	Error   string         `json:"error,omitempty"`
+	Path    string         `json:"path,omitempty"`
+}
+
+type sourceStatStruct struct {
+	List      string          `json:"list"`
+	URL       string          `json:"url"`
+	Runs      int             `json:"runs"`
+	Total     sourceRunStruct `json:"total"`
+	Last      sourceRunStruct `json:"last"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Average alive nodes per run
+func (stat *sourceStatStruct) score() float64 {
+	if stat.Runs == 0 {
+		return 0
+	}
+
+	return float64(stat.Total.Alive) / float64(stat.Runs)
+}
+
+func (run *sourceRunStruct) add(other sourceRunStruct) {
+	run.Fetched += other.Fetched
+	run.Unique += other.Unique
+	run.Alive += other.Alive
+
+	if run.Passed == nil {
+		run.Passed = map[string]int{}
+	}
+	for mode, count := range other.Passed {
+		run.Passed[mode] += count
+	}
+
+	if run.Failed == nil {
+		run.Failed = map[string]int{}
+	}
+	for reason, count := range other.Failed {
+		run.Failed[reason] += count
+	}
+}
+
+func (prov *providerStruct) LoadStats() {
+	statsString, err := helper.ReadFileAsString(SOURCE_STATS_FILENAME)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			prov.logger.Error(err.Error())
+		}
+		return
+	}
+
+	stats := []*sourceStatStruct{}
+	if err := json.Unmarshal([]byte(statsString), &stats); err != nil {
+		prov.logger.Error(err.Error())
+		return
+	}
+
+	for _, stat := range stats {
+		prov.stats[stat.URL] = stat
+	}
+}
+
+// Merge current run into persisted stats and write them down
+func (prov *providerStruct) SaveStats() {
+	prov.Lock()
+	defer prov.Unlock()
+
+	prov.creditUnique()
+	for sourceUrl, run := range prov.runStats {
+		stat, ok := prov.stats[sourceUrl]
+		if !ok {
+			stat = &sourceStatStruct{
+				URL: sourceUrl,
+			}
+			prov.stats[sourceUrl] = stat
+		}
+
+		stat.List = prov.sourceLists[sourceUrl]
+		stat.Runs += 1
+		stat.Total.add(*run)
+		stat.Last = *run
+		stat.UpdatedAt = time.Now()
+	}
+
+	statsByte, err := json.MarshalIndent(prov.sortedStats(), "", "  ")
+	if err != nil {
+		prov.logger.Error(err.Error())
+		return
+	}
+
+	if err := os.WriteFile(SOURCE_STATS_FILENAME, statsByte, 0644); err != nil {
+		prov.logger.Error(err.Error())
+	}
+}
+
+// Record test outcome of a node to every source that yielded it
+func (prov *providerStruct) RecordTestResult(node string, passedModes []string) {
+	var nodeId = prov.getNodeId(node)
+
+	prov.Lock()
+	defer prov.Unlock()
+
+	if len(passedModes) > 0 {
+		prov.nodePassed[nodeId] = passedModes
+	}
+	for _, sourceUrl := range prov.nodeSources[nodeId] {
+		run := prov.getRunStat(sourceUrl)
+		if len(passedModes) > 0 {
+			run.Alive += 1
+		}
+		for _, mode := range passedModes {
+			run.Passed[mode] += 1
+		}
+	}
+}
+
+// Record why a node failed to every source that yielded it
+func (prov *providerStruct) RecordTestFailure(node, reason string) {
+	var nodeId = prov.getNodeId(node)
+
+	prov.Lock()
+	defer prov.Unlock()
+
+	for _, sourceUrl := range prov.nodeSources[nodeId] {
+		prov.getRunStat(sourceUrl).Failed[reason] += 1
+	}
+}
+
+// Ranked report of every known source, best first
+func (prov *providerStruct) StatsReport() string {
+	prov.Lock()
+	defer prov.Unlock()
+
+	var report = []string{"score | runs | alive | unique | fetched | passed (last run) | failed (last run) | url | path | error"}
+	for _, stat := range prov.sortedStats() {
+		var passed = []string{}
+		for _, mode := range sortedKeys(stat.Last.Passed) {
+			passed = append(passed, fmt.Sprintf("%s=%d", mode, stat.Last.Passed[mode]))
+		}
+
+		var failed = []string{}
+		for _, reason := range sortedKeys(stat.Last.Failed) {
+			failed = append(failed, fmt.Sprintf("%s=%d", reason, stat.Last.Failed[reason]))
+		}
+
+		report = append(report, fmt.Sprintf("%.2f | %d | %d | %d | %d | %s | %s | %s | %s | %s", stat.score(), stat.Runs, stat.Last.Alive, stat.Last.Unique, stat.Last.Fetched, strings.Join(passed, " "), strings.Join(failed, " "), stat.URL, stat.Last.Path, stat.Last.Error))
+	}
+
+	var (
+		listRuns = prov.listRuns()
+		lists    = []string{}
+	)
+	for list := range listRuns {
+		lists = append(lists, list)
+	}
+	sort.SliceStable(lists, func(i, j int) bool {
+		if listRuns[lists[i]].Alive != listRuns[lists[j]].Alive {
+			return listRuns[lists[i]].Alive > listRuns[lists[j]].Alive
+		}
+		return lists[i] < lists[j]
+	})
+
+	report = append(report, "", "list | alive | unique | fetched | passed (last run)")
+	for _, list := range lists {
+		var (
+			run    = listRuns[list]
+			passed = []string{}
+		)
+		for _, mode := range sortedKeys(run.Passed) {
+			passed = append(passed, fmt.Sprintf("%s=%d", mode, run.Passed[mode]))
+		}
+
+		report = append(report, fmt.Sprintf("%s | %d | %d | %d | %s", list, run.Alive, run.Unique, run.Fetched, strings.Join(passed, " ")))
+	}
+
+	return strings.Join(report, "\n")
+}
+
+// Current run summed up per list, node yielded by many sources of a list counts once.
+// Must be called with provider locked
+func (prov *providerStruct) listRuns() map[string]*sourceRunStruct {
+	var listRuns = map[string]*sourceRunStruct{}
+	getListRun := func(list string) *sourceRunStruct {
+		run, ok := listRuns[list]
+		if !ok {
+			run = &sourceRunStruct{
+				Passed: map[string]int{},
+			}
+			listRuns[list] = run
+		}
+
+		return run
+	}
+
+	for sourceUrl, run := range prov.runStats {
+		getListRun(prov.sourceLists[sourceUrl]).Fetched += run.Fetched
+	}
+
+	for nodeId, sourceUrls := range prov.nodeSources {
+		var counted = map[string]bool{}
+		for _, sourceUrl := range sourceUrls {
+			list := prov.sourceLists[sourceUrl]
+			if counted[list] {
+				continue
+			}
+			counted[list] = true
+
+			run := getListRun(list)
+			run.Unique += 1
+			if passedModes := prov.nodePassed[nodeId]; len(passedModes) > 0 {
+				run.Alive += 1
+				for _, mode := range passedModes {
+					run.Passed[mode] += 1
+				}
+			}
+		}
+	}
+
+	return listRuns
+}
+
+func (prov *providerStruct) recordFetch(sourceList, sourceUrl string, fetched int) {
+	prov.Lock()
+	defer prov.Unlock()
+
+	prov.getRunStat(sourceUrl).Fetched += fetched
+	prov.sourceLists[sourceUrl] = sourceList
+}
+
+// Distinct nodes of each source, shared node is credited to every source yielding it so fetch order doesn't matter.
+// Must be called with provider locked
+func (prov *providerStruct) creditUnique() {
+	for _, run := range prov.runStats {
+		run.Unique = 0
+	}
+
+	for _, sourceUrls := range prov.nodeSources {
+		for _, sourceUrl := range sourceUrls {
+			prov.getRunStat(sourceUrl).Unique += 1
+		}
+	}
+}
+
+// Record why source couldn't be fetched in current run
+func (prov *providerStruct) recordFailure(sourceList, sourceUrl string, err error) {
+	prov.Lock()
+	defer prov.Unlock()
+
+	prov.getRunStat(sourceUrl).Error = err.Error()
+	prov.sourceLists[sourceUrl] = sourceList
+}
+
+// Record which path source was fetched through, clearing earlier failure
+func (prov *providerStruct) recordPath(sourceList, sourceUrl, path string) {
+	prov.Lock()
+	defer prov.Unlock()
+
+	run := prov.getRunStat(sourceUrl)
+	run.Error = ""
+	run.Path = path
+	prov.sourceLists[sourceUrl] = sourceList
+}
+
+// Must be called with provider locked
+func (prov *providerStruct) getRunStat(sourceUrl string) *sourceRunStruct {
+	run, ok := prov.runStats[sourceUrl]
+	if !ok {
+		run = &sourceRunStruct{
+			Passed: map[string]int{},
+			Failed: map[string]int{},
+		}
+		prov.runStats[sourceUrl] = run
+	}
+
+	return run
+}
+
+func (prov *providerStruct) sortedStats() []*sourceStatStruct {
+	stats := []*sourceStatStruct{}
+	for _, stat := range prov.stats {
+		stats = append(stats, stat)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].score() != stats[j].score() {
+			return stats[i].score() > stats[j].score()
+		}
+		return stats[i].URL < stats[j].URL
+	})
+
+	return stats
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCreditUniqueIgnoresOrder(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	var (
+		first  = filepath.Join(t.TempDir(), "first.txt")
+		second = filepath.Join(t.TempDir(), "second.txt")
+	)
+	os.WriteFile(first, []byte("trojan://pass@1.1.1.1:443#shared\ntrojan://pass@1.0.0.1:443#own\ntrojan://pass@1.0.0.1:443#own-again\n"), 0644)
+	os.WriteFile(second, []byte("trojan://pass@1.1.1.1:443#shared-renamed\n"), 0644)
+
+	for _, order := range [][]string{{first, second}, {second, first}} {
+		prov := MakeSubProvider()
+		for _, path := range order {
+			prov.AddSource("file", "file://"+path)
+		}
+		prov.GatherNodes()
+		prov.SaveStats()
+
+		var got = []int{}
+		for _, path := range []string{first, second} {
+			got = append(got, prov.runStats[path].Fetched, prov.runStats[path].Unique)
+		}
+
+		// Fetched and unique of first, then of second
+		if want := []int{3, 2, 1, 1}; !slices.Equal(got, want) {
+			t.Errorf("order %v: got %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestStatsReportLists(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	var (
+		prov       = MakeSubProvider()
+		sharedNode = "trojan://pass@1.1.1.1:443#shared"
+		dead       = "trojan://pass@1.0.0.1:443#dead"
+	)
+
+	// Shared node is in two sources of one list and in another list
+	prov.recordFetch("list-a", "https://a.example.com/1.txt", 2)
+	prov.recordFetch("list-a", "https://a.example.com/2.txt", 1)
+	prov.recordFetch("list-b", "https://b.example.com/1.txt", 1)
+	prov.addNode(sharedNode, "https://a.example.com/1.txt", nil)
+	prov.addNode(dead, "https://a.example.com/1.txt", nil)
+	prov.addNode(sharedNode, "https://a.example.com/2.txt", nil)
+	prov.addNode(sharedNode, "https://b.example.com/1.txt", nil)
+	prov.RecordTestResult(sharedNode, []string{"cdn", "sni"})
+	prov.RecordTestResult(dead, nil)
+	prov.SaveStats()
+
+	report := strings.Split(prov.StatsReport(), "\n")
+	listReport := report[slices.Index(report, "list | alive | unique | fetched | passed (last run)")+1:]
+
+	if want := []string{
+		"list-a | 1 | 2 | 3 | cdn=1 sni=1",
+		"list-b | 1 | 1 | 1 | cdn=1 sni=1",
+	}; !slices.Equal(listReport, want) {
+		t.Errorf("got %q, want %q", listReport, want)
+	}
+}
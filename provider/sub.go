@@ -7,56 +7,94 @@ import (
 	"slices"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/FoolVPN-ID/megalodon/common/helper"
-	"github.com/FoolVPN-ID/megalodon/constant"
-	fastshot "github.com/opus-domini/fast-shot"
+	"github.com/FoolVPN-ID/megalodon/common/shared"
 )
 
-var configSeparators = []string{"\n", "|", ",", "<br/>"}
+func init() {
+	registerSource("aggregator", func(prov *providerStruct, target string) Source {
+		return &aggregatorSource{prov: prov, url: target}
+	})
+	registerSource("uri", func(prov *providerStruct, target string) Source {
+		return &uriSource{prov: prov, url: target}
+	})
+	registerSource("clash", func(prov *providerStruct, target string) Source {
+		return &clashSource{prov: prov, url: target}
+	})
+}
 
-func (prov *providerStruct) GatherSubFile() {
-	var subFileUrlString, err = helper.ReadFileAsString("./resources/sublist.json")
-	var subFileUrls = []string{}
+// Load sources from sub list file, each entry is either http(s):// or file:// url.
+// List itself is archived too, replay gathers what recording run was told to
+func (prov *providerStruct) GatherSubFile(subFilePath string) {
+	var subFileString, err = prov.readLocal(SUBLIST_ARCHIVE_KEY, func() (string, error) {
+		return helper.ReadFileAsString(subFilePath)
+	})
+	var subFileEntries = []sourceEntryStruct{}
 
 	if err != nil {
 		prov.logger.Error(err.Error())
 		return
 	}
 
-	json.Unmarshal([]byte(subFileUrlString), &subFileUrls)
+	if err := json.Unmarshal([]byte(subFileString), &subFileEntries); err != nil {
+		prov.logger.Error(err.Error())
+		return
+	}
 
-	for _, subFileUrl := range subFileUrls {
-		func() {
-			resp, err := fastshot.NewClient(subFileUrl).
-				Config().SetTimeout(10 * time.Second).
-				Build().GET("").Send()
+	for _, entry := range subFileEntries {
+		prov.AddSource(entry.Type, entry.URL)
+	}
+}
 
-			if err != nil {
-				prov.logger.Error(err.Error())
-				return
-			}
+// Aggregator JSON list of subscriptions
+type aggregatorSource struct {
+	prov  *providerStruct
+	url   string
+	depth int
+}
 
-			if resp.Status().Code() == 200 {
-				var subFile = []providerSubStruct{}
-				if err := resp.Body().AsJSON(&subFile); err == nil {
-					prov.subs = append(prov.subs, subFile...)
-				}
-			}
-		}()
+func (source *aggregatorSource) Fetch(nodes chan<- NodeStruct) error {
+	var (
+		prov    = source.prov
+		subFile = []providerSubStruct{}
+	)
+
+	prov.markVisited(source.url)
+	subFileBody, _, err := prov.fetch(source.url)
+	if err != nil {
+		prov.recordFailure(source.url, source.url, err)
+		prov.addFailedSource(source)
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(subFileBody), &subFile); err != nil {
+		return err
 	}
+
+	prov.fetchSubList(nodes, subFile, source.url, source.depth)
+
+	return nil
 }
 
-func (prov *providerStruct) GatherNodes() {
+// Fetch every enabled subscription of aggregator list
+func (prov *providerStruct) fetchSubList(nodes chan<- NodeStruct, subFile []providerSubStruct, list string, depth int) {
 	var (
 		wg    = sync.WaitGroup{}
 		queue = make(chan struct{}, 10)
 	)
 
-	for i, sub := range prov.subs {
-		var subUrls = strings.Split(sub.URL, "|")
-		for x, subUrl := range subUrls {
+	for _, sub := range subFile {
+		if !sub.isEnabled() {
+			continue
+		}
+
+		for _, subUrl := range strings.Split(sub.URL, "|") {
+			// Same url may be listed by many lists or discovered again, fetch it once per run
+			if subUrl == "" || !prov.visit(subUrl) {
+				continue
+			}
+
 			wg.Add(1)
 			queue <- struct{}{}
 
@@ -66,72 +104,190 @@ func (prov *providerStruct) GatherNodes() {
 					<-queue
 				}()
 				defer func() {
-					recover()
+					if err := recover(); err != nil {
+						prov.logger.Error(fmt.Sprintf("[%s] Recover from panic: %v", subUrl, err))
+					}
 				}()
 
-				resp, err := fastshot.NewClient(subUrl).
-					Config().SetTimeout(10 * time.Second).
-					Build().GET("").Send()
-				if err != nil {
-					panic(err)
+				candidates := sub.resolveUrls(subUrl, prov.now())
+				if sub.UpdateMethod == updateMethodPageRelease {
+					candidates = prov.resolvePageRelease(subUrl)
 				}
 
-				if resp.Status().Code() == 200 {
-					var (
-						nodes       = []string{}
-						textBody, _ = resp.Body().AsString()
-					)
+				subSource := subUrlSource{
+					prov:       prov,
+					list:       list,
+					url:        subUrl,
+					candidates: candidates,
+					depth:      depth,
+				}
+				subSource.Fetch(nodes)
+			})()
+		}
+	}
 
-					if len(textBody) < 100 {
-						return
-					}
+	// Wait for all goroutines
+	wg.Wait()
+}
 
-					if !strings.Contains(textBody, "://") {
-						parsedBody := helper.DecodeBase64Safe(textBody)
-						if parsedBody == textBody {
-							if parsedBodyByte, err := base64.StdEncoding.DecodeString(textBody); err == nil {
-								parsedBody = string(parsedBodyByte)
-							} else {
-								if parsedBodyByte, err = base64.RawStdEncoding.DecodeString(textBody); err == nil {
-									parsedBody = string(parsedBodyByte)
-								} else {
-									prov.logger.Error(err.Error())
-								}
-							}
-						}
-
-						textBody = parsedBody
-					}
+// Single subscription url of an aggregator list
+type subUrlSource struct {
+	prov       *providerStruct
+	list       string
+	url        string
+	candidates []string
+	depth      int
+}
 
-					for _, separator := range configSeparators {
-						nodes = append(nodes, strings.Split(textBody, separator)...)
-					}
+func (source *subUrlSource) Fetch(nodes chan<- NodeStruct) error {
+	var (
+		prov    = source.prov
+		lastErr error
+	)
 
-					var addedNodesCount = 0
-					for _, node := range nodes {
-						for _, acceptedType := range constant.ACCEPTED_TYPES {
-							if strings.HasPrefix(node, acceptedType) {
-								addedNodesCount += 1
-								prov.addNode(node)
-							}
-						}
-					}
+	// Count the run against the source, even if nothing fetched
+	prov.recordFetch(source.list, source.url, 0)
 
-					prov.logger.Info(fmt.Sprintf("[[%d/%d]%d/%d] [%d] [%d] %s\n", x, len(subUrls), i, len(prov.subs), addedNodesCount, len(prov.Nodes), subUrl))
-				}
-			})()
+	for _, resolvedUrl := range source.candidates {
+		textBody, path, err := prov.fetch(resolvedUrl)
+		if err != nil {
+			prov.logger.Error(err.Error())
+			lastErr = err
+			continue
+		}
+
+		prov.recordPath(source.list, source.url, path)
+
+		userinfo, err := prov.checkUserinfo(source.list, source.url, resolvedUrl)
+		if err != nil {
+			prov.logger.Error(err.Error())
+			return nil
 		}
+
+		if len(textBody) >= 100 {
+			prov.emitBody(nodes, textBody, source.url, source.list, userinfo, prov.parseBody)
+			prov.discover(nodes, textBody, source.list, source.depth)
+		}
+		return nil
 	}
 
-	// Wait for all goroutines
-	wg.Wait()
+	if lastErr != nil {
+		prov.recordFailure(source.list, source.url, lastErr)
+		prov.addFailedSource(source)
+	}
+
+	return lastErr
+}
+
+// Plain, base64 or any other format parseBody understands
+type uriSource struct {
+	prov *providerStruct
+	url  string
+}
+
+func (source *uriSource) Fetch(nodes chan<- NodeStruct) error {
+	return source.prov.fetchSource(nodes, source, source.url, source.prov.parseBody)
+}
+
+// Clash config, parsed as is without format detection
+type clashSource struct {
+	prov *providerStruct
+	url  string
+}
+
+func (source *clashSource) Fetch(nodes chan<- NodeStruct) error {
+	return source.prov.fetchSource(nodes, source, source.url, source.prov.parseClashBody)
+}
+
+// Fetch url that is a source on its own, record how it went and emit what parse finds in body
+func (prov *providerStruct) fetchSource(nodes chan<- NodeStruct, source Source, sourceUrl string, parse bodyParser) error {
+	prov.recordFetch(sourceUrl, sourceUrl, 0)
+	prov.markVisited(sourceUrl)
+
+	textBody, path, err := prov.fetch(sourceUrl)
+	if err != nil {
+		prov.recordFailure(sourceUrl, sourceUrl, err)
+		prov.addFailedSource(source)
+		return err
+	}
+	prov.recordPath(sourceUrl, sourceUrl, path)
+
+	userinfo, err := prov.checkUserinfo(sourceUrl, sourceUrl, sourceUrl)
+	if err != nil {
+		prov.logger.Error(err.Error())
+		return nil
+	}
+
+	prov.emitBody(nodes, textBody, sourceUrl, sourceUrl, userinfo, parse)
+	prov.discover(nodes, textBody, sourceUrl, 0)
+	return nil
+}
+
+func (prov *providerStruct) parseBody(textBody string) []string {
+	var nodes = []string{}
+
+	switch {
+	case isSingboxBody(textBody):
+		return prov.parseSingboxBody(textBody)
+	case isSIP008Body(textBody):
+		return prov.parseSIP008Body(textBody)
+	case isClashBody(textBody):
+		return prov.parseClashBody(textBody)
+	}
+
+	textBody, err := decodeBody(textBody)
+	if err != nil {
+		prov.logger.Error(err.Error())
+	}
+
+	for _, node := range extractNodeURIs(textBody) {
+		if convertedNode := prov.convertURINode(node); convertedNode != "" {
+			nodes = append(nodes, convertedNode)
+		}
+	}
+
+	return nodes
 }
 
-func (prov *providerStruct) addNode(node string) {
+// Plain text of body, subscriptions without any uri in them are base64 of one
+func decodeBody(textBody string) (string, error) {
+	if strings.Contains(textBody, "://") {
+		return textBody, nil
+	}
+
+	parsedBody := helper.DecodeBase64Safe(textBody)
+	if parsedBody != textBody {
+		return parsedBody, nil
+	}
+
+	if parsedBodyByte, err := base64.StdEncoding.DecodeString(textBody); err == nil {
+		return string(parsedBodyByte), nil
+	}
+
+	parsedBodyByte, err := base64.RawStdEncoding.DecodeString(textBody)
+	if err != nil {
+		return textBody, err
+	}
+
+	return string(parsedBodyByte), nil
+}
+
+// Add node and remember where it comes from
+func (prov *providerStruct) addNode(node, sourceUrl string, userinfo *shared.SubUserinfoStruct) {
+	var nodeId = prov.getNodeId(node)
+
 	prov.Lock()
 	defer prov.Unlock()
 
-	if !slices.Contains(prov.Nodes, node) {
+	if !slices.Contains(prov.nodeSources[nodeId], sourceUrl) {
+		prov.nodeSources[nodeId] = append(prov.nodeSources[nodeId], sourceUrl)
+	}
+	if userinfo != nil && prov.nodeUserinfos[nodeId] == nil {
+		prov.nodeUserinfos[nodeId] = userinfo
+	}
+
+	if !prov.nodeIds[nodeId] {
+		prov.nodeIds[nodeId] = true
 		prov.Nodes = append(prov.Nodes, node)
 	}
 }
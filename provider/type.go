@@ -6,5 +6,5 @@ type providerSubStruct struct {
 	Site         string `json:"site"`
 	URL          string `json:"url"`
 	UpdateMethod string `json:"update_method"`
-	Enabled      bool   `json:"enabled"`
+	Enabled      *bool  `json:"enabled"`
 }
@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Update methods used by aggregator sub lists
+const (
+	updateMethodAuto        = "auto"         // Date in url path is rolled like change_date, url kept as is otherwise
+	updateMethodChangeDate  = "change_date"  // Date in url path changes daily
+	updateMethodPageRelease = "page_release" // Url is a page linking the current sub file
+)
+
+// Match dates like 2006/01/02, 2006-01-02, 20060102 or 2006/01 as whole path segment or its part
+var urlDatePattern = regexp.MustCompile(`(^|[/_-])(20\d{2})([-_/]?)(0[1-9]|1[0-2])(?:([-_/]?)(0[1-9]|[12]\d|3[01]))?([/._-]|$)`)
+
+// Missing enabled field means the list doesn't support toggling, treat it as enabled
+func (sub providerSubStruct) isEnabled() bool {
+	return sub.Enabled == nil || *sub.Enabled
+}
+
+// Compute candidate urls to fetch, ordered by preference
+func (sub providerSubStruct) resolveUrls(subUrl string, now time.Time) []string {
+	switch sub.UpdateMethod {
+	case updateMethodChangeDate:
+		return getDatedUrls(subUrl, now)
+	case updateMethodAuto:
+		if hasUrlDate(subUrl) {
+			return getDatedUrls(subUrl, now)
+		}
+		return []string{subUrl}
+	default:
+		return []string{subUrl}
+	}
+}
+
+// Today's file may not be published yet, fallback to yesterday and the listed url
+func getDatedUrls(subUrl string, now time.Time) []string {
+	candidates := []string{}
+	for _, date := range []time.Time{now, now.AddDate(0, 0, -1)} {
+		if candidate := applyDateToUrl(subUrl, date); !slices.Contains(candidates, candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if !slices.Contains(candidates, subUrl) {
+		candidates = append(candidates, subUrl)
+	}
+	return candidates
+}
+
+// Release page links sub files, newest date first, page itself last
+func (prov *providerStruct) resolvePageRelease(pageUrl string) []string {
+	textBody, _, err := prov.fetch(pageUrl)
+	if err != nil {
+		prov.logger.Error(err.Error())
+		return []string{pageUrl}
+	}
+
+	var links = []string{}
+	for _, link := range subUrlPattern.FindAllString(textBody, -1) {
+		if link != pageUrl && isSubUrl(link) && !slices.Contains(links, link) {
+			links = append(links, link)
+		}
+	}
+
+	slices.SortStableFunc(links, func(a, b string) int {
+		return strings.Compare(getUrlDate(b), getUrlDate(a))
+	})
+
+	return append(links, pageUrl)
+}
+
+func hasUrlDate(subUrl string) bool {
+	_, urlPath, _ := splitUrlPath(subUrl)
+	return urlDatePattern.MatchString(urlPath)
+}
+
+// Date found in url path as yyyymmdd, empty if there is none
+func getUrlDate(subUrl string) string {
+	_, urlPath, _ := splitUrlPath(subUrl)
+
+	var date = ""
+	for _, submatch := range urlDatePattern.FindAllStringSubmatch(urlPath, -1) {
+		day := submatch[6]
+		if day == "" {
+			day = "00"
+		}
+		date = max(date, submatch[2]+submatch[4]+day)
+	}
+
+	return date
+}
+
+func applyDateToUrl(subUrl string, date time.Time) string {
+	head, urlPath, tail := splitUrlPath(subUrl)
+
+	urlPath = urlDatePattern.ReplaceAllStringFunc(urlPath, func(match string) string {
+		var (
+			submatch = urlDatePattern.FindStringSubmatch(match)
+			result   = submatch[1] + date.Format("2006") + submatch[3] + date.Format("01")
+		)
+
+		if submatch[6] != "" {
+			result += submatch[5] + date.Format("02")
+		}
+
+		return result + submatch[7]
+	})
+
+	return head + urlPath + tail
+}
+
+// Split raw url around its path, so host and query are never rewritten
+func splitUrlPath(subUrl string) (string, string, string) {
+	parsedUrl, err := url.Parse(subUrl)
+	if err != nil || parsedUrl.Host == "" {
+		return subUrl, "", ""
+	}
+
+	var (
+		head = parsedUrl.Scheme + "://" + parsedUrl.Host
+		rest = strings.TrimPrefix(subUrl, head)
+	)
+	if len(rest) == len(subUrl) {
+		return subUrl, "", ""
+	}
+
+	if i := strings.IndexAny(rest, "?#"); i >= 0 {
+		return head, rest[:i], rest[i:]
+	}
+	return head, rest, ""
+}
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestResolveUrls(t *testing.T) {
+	var now = time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name   string
+		method string
+		url    string
+		want   []string
+	}{
+		{"none", "", "https://example.com/2024/01/02/sub.txt", []string{"https://example.com/2024/01/02/sub.txt"}},
+		{
+			"change date",
+			updateMethodChangeDate,
+			"https://example.com/2024/01/02/sub.txt",
+			[]string{"https://example.com/2025/03/10/sub.txt", "https://example.com/2025/03/09/sub.txt", "https://example.com/2024/01/02/sub.txt"},
+		},
+		{
+			"change date in file name",
+			updateMethodChangeDate,
+			"https://example.com/nodes/20240102.txt",
+			[]string{"https://example.com/nodes/20250310.txt", "https://example.com/nodes/20250309.txt", "https://example.com/nodes/20240102.txt"},
+		},
+		{
+			"month only",
+			updateMethodChangeDate,
+			"https://example.com/2024-01/sub.txt",
+			[]string{"https://example.com/2025-03/sub.txt", "https://example.com/2024-01/sub.txt"},
+		},
+		{
+			"host and query untouched",
+			updateMethodChangeDate,
+			"https://cdn20240102.example.com/sub-2024-01-02.yaml?token=20240102",
+			[]string{
+				"https://cdn20240102.example.com/sub-2025-03-10.yaml?token=20240102",
+				"https://cdn20240102.example.com/sub-2025-03-09.yaml?token=20240102",
+				"https://cdn20240102.example.com/sub-2024-01-02.yaml?token=20240102",
+			},
+		},
+		{"digits inside segment", updateMethodChangeDate, "https://example.com/v202401/sub.txt", []string{"https://example.com/v202401/sub.txt"}},
+		{
+			"auto with date",
+			updateMethodAuto,
+			"https://example.com/2024/01/02/sub.txt",
+			[]string{"https://example.com/2025/03/10/sub.txt", "https://example.com/2025/03/09/sub.txt", "https://example.com/2024/01/02/sub.txt"},
+		},
+		{"auto without date", updateMethodAuto, "https://example.com/sub.txt", []string{"https://example.com/sub.txt"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sub := providerSubStruct{UpdateMethod: test.method}
+			if got := sub.resolveUrls(test.url, now); !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolvePageRelease(t *testing.T) {
+	var (
+		pageUrl = "file://testdata/release.html"
+		want    = []string{
+			"https://example.com/releases/2024-03-09/sub.txt",
+			"https://example.com/releases/2024-01-02/sub.txt",
+			pageUrl,
+		}
+	)
+
+	if got := MakeSubProvider().resolvePageRelease(pageUrl); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
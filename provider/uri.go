@@ -0,0 +1,225 @@
+package provider
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+)
+
+// Convert URI schemes the upstream parser can't handle into sing-box nodes,
+// anything else is returned as is
+func (prov *providerStruct) convertURINode(node string) string {
+	var outbounds = []option.Outbound{}
+
+	nodeUrl, err := url.Parse(strings.TrimSpace(node))
+	if err != nil {
+		return node
+	}
+
+	switch nodeUrl.Scheme {
+	case "hysteria2", "hy2":
+		outbounds = append(outbounds, parseHysteria2URI(nodeUrl))
+	case "tuic":
+		outbounds = append(outbounds, parseTUICURI(nodeUrl))
+	case "anytls":
+		outbounds = append(outbounds, parseAnyTLSURI(nodeUrl))
+	case "ss":
+		if !strings.HasPrefix(getSSPlugin(nodeUrl), "shadow-tls") {
+			return node
+		}
+		outbounds = append(outbounds, parseShadowTLSURI(nodeUrl)...)
+	default:
+		return node
+	}
+
+	if nodes := outboundsToNodes(outbounds); len(nodes) > 0 {
+		return nodes[0]
+	}
+
+	return ""
+}
+
+func parseHysteria2URI(nodeUrl *url.URL) option.Outbound {
+	var (
+		query   = nodeUrl.Query()
+		options = option.Hysteria2OutboundOptions{
+			ServerOptions: parseServerOptions(nodeUrl, 443),
+			Password:      nodeUrl.User.String(),
+		}
+	)
+
+	if password, err := url.PathUnescape(options.Password); err == nil {
+		options.Password = password
+	}
+	if obfs := query.Get("obfs"); obfs != "" && obfs != "none" {
+		options.Obfs = &option.Hysteria2Obfs{
+			Type:     obfs,
+			Password: query.Get("obfs-password"),
+		}
+	}
+	options.UpMbps, _ = strconv.Atoi(query.Get("up"))
+	options.DownMbps, _ = strconv.Atoi(query.Get("down"))
+	options.TLS = parseURITLSOptions(nodeUrl)
+
+	return option.Outbound{
+		Type:    C.TypeHysteria2,
+		Tag:     getURITag(nodeUrl),
+		Options: &options,
+	}
+}
+
+func parseTUICURI(nodeUrl *url.URL) option.Outbound {
+	var (
+		query       = nodeUrl.Query()
+		password, _ = nodeUrl.User.Password()
+		options     = option.TUICOutboundOptions{
+			ServerOptions:     parseServerOptions(nodeUrl, 443),
+			UUID:              nodeUrl.User.Username(),
+			Password:          password,
+			CongestionControl: query.Get("congestion_control"),
+			UDPRelayMode:      query.Get("udp_relay_mode"),
+		}
+	)
+
+	options.TLS = parseURITLSOptions(nodeUrl)
+	if len(options.TLS.ALPN) == 0 {
+		options.TLS.ALPN = []string{"h3"}
+	}
+
+	return option.Outbound{
+		Type:    C.TypeTUIC,
+		Tag:     getURITag(nodeUrl),
+		Options: &options,
+	}
+}
+
+func parseAnyTLSURI(nodeUrl *url.URL) option.Outbound {
+	options := option.AnyTLSOutboundOptions{
+		ServerOptions: parseServerOptions(nodeUrl, 443),
+		Password:      nodeUrl.User.Username(),
+	}
+
+	options.TLS = parseURITLSOptions(nodeUrl)
+
+	return option.Outbound{
+		Type:    C.TypeAnyTLS,
+		Tag:     getURITag(nodeUrl),
+		Options: &options,
+	}
+}
+
+// ss://method:password@server:port?plugin=shadow-tls;host=example.com;password=xxx;version=3
+func parseShadowTLSURI(nodeUrl *url.URL) []option.Outbound {
+	var (
+		tag        = getURITag(nodeUrl)
+		detourTag  = tag + "-st"
+		userCred   = nodeUrl.User.String()
+		ssOptions  = option.ShadowsocksOutboundOptions{}
+		stlsTLS    = &option.OutboundTLSOptions{Enabled: true}
+		stlsOption = option.ShadowTLSOutboundOptions{
+			ServerOptions: parseServerOptions(nodeUrl, 443),
+			Version:       3,
+		}
+	)
+
+	if decodedCred, err := url.PathUnescape(userCred); err == nil {
+		userCred = decodedCred
+	}
+	if method, password, found := strings.Cut(helper.DecodeBase64Safe(userCred), ":"); found {
+		ssOptions.Method, ssOptions.Password = method, password
+	}
+	ssOptions.Detour = detourTag
+
+	for _, pluginOpt := range strings.Split(getSSPlugin(nodeUrl), ";") {
+		key, value, _ := strings.Cut(pluginOpt, "=")
+		switch key {
+		case "host":
+			stlsTLS.ServerName = value
+		case "password":
+			stlsOption.Password = value
+		case "version":
+			if version, err := strconv.Atoi(value); err == nil {
+				stlsOption.Version = version
+			}
+		}
+	}
+	stlsOption.TLS = stlsTLS
+
+	return []option.Outbound{
+		{
+			Type:    C.TypeShadowsocks,
+			Tag:     tag,
+			Options: &ssOptions,
+		},
+		{
+			Type:    C.TypeShadowTLS,
+			Tag:     detourTag,
+			Options: &stlsOption,
+		},
+	}
+}
+
+func getURITag(nodeUrl *url.URL) string {
+	if nodeUrl.Fragment != "" {
+		return nodeUrl.Fragment
+	}
+
+	return nodeUrl.Scheme
+}
+
+// Plugin options are separated by semicolon, which url.Query refuses to parse
+func getSSPlugin(nodeUrl *url.URL) string {
+	for _, param := range strings.Split(nodeUrl.RawQuery, "&") {
+		if key, value, _ := strings.Cut(param, "="); key == "plugin" {
+			if unescapedValue, err := url.QueryUnescape(value); err == nil {
+				return unescapedValue
+			}
+			return value
+		}
+	}
+
+	return ""
+}
+
+func parseServerOptions(nodeUrl *url.URL, defaultPort uint16) option.ServerOptions {
+	serverOptions := option.ServerOptions{
+		Server:     nodeUrl.Hostname(),
+		ServerPort: defaultPort,
+	}
+
+	if port, err := strconv.Atoi(nodeUrl.Port()); err == nil {
+		serverOptions.ServerPort = uint16(port)
+	}
+
+	return serverOptions
+}
+
+func parseURITLSOptions(nodeUrl *url.URL) *option.OutboundTLSOptions {
+	var (
+		query      = nodeUrl.Query()
+		tlsOptions = option.OutboundTLSOptions{
+			Enabled:    true,
+			ServerName: nodeUrl.Hostname(),
+		}
+	)
+
+	if sni := query.Get("sni"); sni != "" {
+		tlsOptions.ServerName = sni
+	} else if peer := query.Get("peer"); peer != "" {
+		tlsOptions.ServerName = peer
+	}
+	if alpn := query.Get("alpn"); alpn != "" {
+		tlsOptions.ALPN = strings.Split(alpn, ",")
+	}
+	for _, key := range []string{"insecure", "allow_insecure", "allowInsecure", "skip-cert-verify"} {
+		if value := query.Get(key); value == "1" || value == "true" {
+			tlsOptions.Insecure = true
+		}
+	}
+
+	return &tlsOptions
+}
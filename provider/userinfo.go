@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/FoolVPN-ID/megalodon/common/shared"
+)
+
+// Quota of subscription its nodes came from, nil if unknown
+func (prov *providerStruct) GetNodeUserinfo(node string) *shared.SubUserinfoStruct {
+	var nodeId = prov.getNodeId(node)
+
+	prov.Lock()
+	defer prov.Unlock()
+
+	return prov.nodeUserinfos[nodeId]
+}
+
+// Userinfo of fetched url, error if subscription is expired or out of quota
+func (prov *providerStruct) checkUserinfo(sourceList, sourceUrl, fetchedUrl string) (*shared.SubUserinfoStruct, error) {
+	prov.Lock()
+	userinfo := prov.userinfos[fetchedUrl]
+	prov.Unlock()
+
+	if userinfo == nil {
+		return nil, nil
+	}
+
+	var err error
+	switch {
+	case userinfo.IsExpired(prov.now()):
+		err = fmt.Errorf("subscription expired at %s: %s", time.Unix(userinfo.Expire, 0).Format(time.DateOnly), sourceUrl)
+	case userinfo.IsExhausted():
+		err = fmt.Errorf("subscription quota exhausted: %s", sourceUrl)
+	}
+
+	if err != nil {
+		prov.recordFailure(sourceList, sourceUrl, err)
+		return nil, err
+	}
+
+	return userinfo, nil
+}
+
+func (prov *providerStruct) setUserinfo(fetchedUrl, header string) {
+	prov.Lock()
+	defer prov.Unlock()
+
+	if userinfo := shared.ParseSubUserinfo(header); userinfo != nil {
+		prov.userinfos[fetchedUrl] = userinfo
+	} else {
+		delete(prov.userinfos, fetchedUrl)
+	}
+}
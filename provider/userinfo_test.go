@@ -0,0 +1,30 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FoolVPN-ID/megalodon/common/shared"
+)
+
+func TestCheckUserinfoOnReplay(t *testing.T) {
+	var (
+		recordedAt = time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+		subUrl     = "https://example.com/sub.txt"
+	)
+
+	// Valid when recorded, long expired by now
+	prov := MakeSubProvider()
+	prov.replayDir = t.TempDir()
+	prov.replayAt = recordedAt
+	prov.userinfos[subUrl] = &shared.SubUserinfoStruct{Total: 1, Expire: recordedAt.AddDate(0, 0, 1).Unix()}
+
+	if _, err := prov.checkUserinfo(subUrl, subUrl, subUrl); err != nil {
+		t.Errorf("replay judged expiry against wall clock: %v", err)
+	}
+
+	prov.replayAt = recordedAt.AddDate(0, 0, 2)
+	if _, err := prov.checkUserinfo(subUrl, subUrl, subUrl); err == nil {
+		t.Error("expected subscription expired at replay time")
+	}
+}
@@ -0,0 +1,330 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/FoolVPN-ID/megalodon/constant"
+	box "github.com/sagernet/sing-box"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const batchProbeConcurrency = 100
+
+// Single outbound hosted by shared box, node rewritten for a matrix entry or as is for udp probe
+type testUnitStruct struct {
+	node      *testNodeStruct
+	mode      testModeStruct
+	entry     TestEntryStruct
+	outbounds []map[string]any
+}
+
+// Sing-box hosting many units as outbounds, probes dial them in-process
+type sharedBoxStruct struct {
+	instance *box.Box
+	cancel   context.CancelFunc
+}
+
+func (sharedBox *sharedBoxStruct) dialer(tag string) (N.Dialer, error) {
+	outbound, ok := sharedBox.instance.Outbound().Outbound(tag)
+	if !ok {
+		return nil, fmt.Errorf("outbound not found: %s", tag)
+	}
+
+	return outbound, nil
+}
+
+func (sharedBox *sharedBoxStruct) close() {
+	sharedBox.instance.Close()
+	sharedBox.cancel()
+}
+
+// Test nodes against every matrix entry, sharing sing-box instances instead of one per test
+func (sb *sandboxStruct) TestBatch(rawConfigs []string, offset, total int) {
+	if sb.IsFull() {
+		return
+	}
+
+	var (
+		nodes = []*testNodeStruct{}
+		units = []*testUnitStruct{}
+	)
+
+	for i, rawConfig := range rawConfigs {
+		node, err := sb.prepareNode(rawConfig, offset+i)
+		if err != nil {
+			if !errors.Is(err, errBlacklisted) {
+				sb.addFailure(base64.StdEncoding.EncodeToString([]byte(rawConfig)), classifyFailure(err), err.Error())
+			}
+			sb.log.Error(fmt.Sprintf("[%d/%d] %s", offset+i, total, err.Error()))
+			continue
+		}
+		nodes = append(nodes, node)
+		units = append(units, sb.makeUnits(node)...)
+	}
+
+	sb.runUnits(units, func(dialer N.Dialer, unit *testUnitStruct) {
+		modeResult, err := probeThroughDialer(dialer, unit.mode)
+		if err != nil {
+			unit.node.addFailure(err)
+			sb.log.Error(fmt.Sprintf("[%d/%d] [%s %s] %s", unit.node.index, total, unit.mode.Name, unit.entry, err.Error()))
+			return
+		}
+		modeResult.ExitGeoip = sb.geo.resolve(dialer, modeResult.ExitGeoip)
+		modeResult.EntryGeoip = sb.geo.resolveEntry(unit.entryServer())
+		modeResult.unit = unit
+
+		passed := unit.node.addPass(unit.mode, unit.entry, modeResult)
+		sb.log.Success(fmt.Sprintf("[%d/%d] [%d+%d] %v %s %s %s %dms", unit.node.index, total, len(sb.Results), len(passed), passed, unit.entry, modeResult.ExitGeoip.Country, modeResult.ExitGeoip.AsOrganization, modeResult.Latency.TTFB))
+	})
+
+	// Udp only matters for nodes that work at all, tested as is
+	if sb.udpResolver != "" {
+		udpUnits := []*testUnitStruct{}
+		for _, node := range nodes {
+			if len(node.result.TestPassed) > 0 {
+				udpUnits = append(udpUnits, &testUnitStruct{
+					node:      node,
+					outbounds: getNodeOutboundMappings(node.singConfig),
+				})
+			}
+		}
+
+		sb.runUnits(udpUnits, func(dialer N.Dialer, unit *testUnitStruct) {
+			if err := probeUDP(dialer, sb.udpResolver); err != nil {
+				sb.log.Error(fmt.Sprintf("[%d/%d] [udp] %s", unit.node.index, total, err.Error()))
+				return
+			}
+
+			unit.node.Lock()
+			unit.node.result.UDP = true
+			unit.node.Unlock()
+		})
+	}
+
+	for _, node := range nodes {
+		if len(node.result.TestPassed) > 0 {
+			sb.addResult(node.result)
+		} else {
+			reason := node.failureReason()
+			sb.addBlacklist(node.md5, reason)
+			sb.addFailure(node.result.RawConfig, reason, node.lastError)
+		}
+	}
+}
+
+// Probe every unit through shared boxes, a broken outbound fails whole box so split until it's isolated
+func (sb *sandboxStruct) runUnits(units []*testUnitStruct, probe func(dialer N.Dialer, unit *testUnitStruct)) {
+	if len(units) == 0 {
+		return
+	}
+
+	sharedBox, err := startSharedBox(units)
+	if err != nil {
+		if len(units) == 1 {
+			units[0].node.addFailure(makeFailure(FailureConfig, err))
+			sb.log.Error(fmt.Sprintf("[%d] %s", units[0].node.index, err.Error()))
+			return
+		}
+
+		half := len(units) / 2
+		sb.runUnits(units[:half], probe)
+		sb.runUnits(units[half:], probe)
+		return
+	}
+	defer sharedBox.close()
+
+	var (
+		wg    = sync.WaitGroup{}
+		queue = make(chan struct{}, batchProbeConcurrency)
+	)
+
+	for i, unit := range units {
+		wg.Add(1)
+		queue <- struct{}{}
+
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					sb.log.Error(fmt.Sprintf("Recover from panic: %v", err))
+				}
+
+				wg.Done()
+				<-queue
+			}()
+
+			dialer, err := sharedBox.dialer(getUnitTag(i))
+			if err != nil {
+				sb.log.Error(err.Error())
+				return
+			}
+
+			probe(dialer, unit)
+		}()
+	}
+
+	// Wait for all goroutines
+	wg.Wait()
+}
+
+// Node rewritten for every matrix entry that applies to it
+func (sb *sandboxStruct) makeUnits(node *testNodeStruct) []*testUnitStruct {
+	var units = []*testUnitStruct{}
+
+	for _, mode := range sb.matrix {
+		// QUIC can't be fronted by CDN and shadowtls dials its own server, only sni mode make sense
+		if mode.Type == testModeCDN && (slices.Contains(constant.QUIC_OUTBOUND_TYPES, node.result.Outbound.Type) || node.result.Detour.Type == C.TypeShadowTLS) {
+			continue
+		}
+
+		for _, entry := range mode.entries() {
+			outbounds := getNodeOutboundMappings(node.singConfig)
+			applyTestEntry(outbounds, node.singConfig.Route.Final, mode, entry)
+
+			units = append(units, &testUnitStruct{
+				node:      node,
+				mode:      mode,
+				entry:     entry,
+				outbounds: outbounds,
+			})
+		}
+	}
+
+	return units
+}
+
+func startSharedBox(units []*testUnitStruct) (*sharedBoxStruct, error) {
+	var outbounds = []map[string]any{}
+	for i, unit := range units {
+		outbounds = append(outbounds, renameUnitOutbounds(unit, getUnitTag(i))...)
+	}
+
+	// Every node config is built with the same dns
+	baseMapping := map[string]any{}
+	baseByte, _ := json.Marshal(units[0].node.singConfig)
+	json.Unmarshal(baseByte, &baseMapping)
+
+	boxConfigMapping := map[string]any{
+		"log": map[string]any{
+			"disabled": true,
+		},
+		"dns": baseMapping["dns"],
+		"outbounds": append(outbounds, map[string]any{
+			"tag":  "direct",
+			"type": "direct",
+		}, map[string]any{
+			"tag":  guardOutboundTag,
+			"type": guardOutboundType,
+		}),
+		"route": map[string]any{
+			"final": "direct",
+		},
+	}
+
+	boxConfigByte, err := json.Marshal(boxConfigMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	outboundRegistry := include.OutboundRegistry()
+	registerGuardOutbound(outboundRegistry)
+	ctx = box.Context(ctx, include.InboundRegistry(), outboundRegistry, include.EndpointRegistry(), include.DNSTransportRegistry(), include.ServiceRegistry())
+
+	boxConfig := option.Options{}
+	if err := boxConfig.UnmarshalJSONContext(ctx, boxConfigByte); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	boxInstance, err := box.New(box.Options{
+		Context: ctx,
+		Options: boxConfig,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if err := boxInstance.Start(); err != nil {
+		boxInstance.Close()
+		cancel()
+		return nil, err
+	}
+
+	return &sharedBoxStruct{
+		instance: boxInstance,
+		cancel:   cancel,
+	}, nil
+}
+
+// Copy unit outbounds under tags unique within the box, main one takes unit tag
+func renameUnitOutbounds(unit *testUnitStruct, tag string) []map[string]any {
+	var (
+		outbounds        = []map[string]any{}
+		tags             = map[string]string{}
+		outboundsByte, _ = json.Marshal(unit.outbounds)
+	)
+	json.Unmarshal(outboundsByte, &outbounds)
+
+	for i, outbound := range outbounds {
+		oldTag, _ := outbound["tag"].(string)
+		if oldTag == unit.node.singConfig.Route.Final {
+			tags[oldTag] = tag
+		} else {
+			tags[oldTag] = fmt.Sprintf("%s-%d", tag, i)
+		}
+		outbound["tag"] = tags[oldTag]
+	}
+
+	// Outbound dialing node server directly goes through guard instead
+	for _, outbound := range outbounds {
+		if detour, ok := outbound["detour"].(string); ok {
+			outbound["detour"] = tags[detour]
+		} else {
+			outbound["detour"] = guardOutboundTag
+		}
+	}
+
+	return outbounds
+}
+
+// Host the unit connects to first, cdn host or the node server behind any detour
+func (unit *testUnitStruct) entryServer() string {
+	if unit.mode.Type == testModeCDN {
+		return unit.entry.Host
+	}
+
+	if server := getOutboundServer(unit.node.result.Detour); server != "" {
+		return server
+	}
+	return getOutboundServer(unit.node.result.Outbound)
+}
+
+func getUnitTag(index int) string {
+	return fmt.Sprintf("u%d", index)
+}
+
+// Record passed entry, returns modes passed so far
+func (node *testNodeStruct) addPass(mode testModeStruct, entry TestEntryStruct, modeResult ModeResultStruct) []string {
+	node.Lock()
+	defer node.Unlock()
+
+	if !slices.Contains(node.result.TestPassed, mode.Name) {
+		node.result.TestPassed = append(node.result.TestPassed, mode.Name)
+	}
+	node.result.PassedEntries = append(node.result.PassedEntries, entry)
+	if current, ok := node.result.Modes[mode.Name]; !ok || modeResult.Latency.isBetterThan(current.Latency) {
+		node.result.Modes[mode.Name] = modeResult
+	}
+
+	return slices.Clone(node.result.TestPassed)
+}
@@ -19,9 +19,18 @@ func (sb *sandboxStruct) LoadBlacklist() {
 	}
 	defer file.Close()
 
+	// Each line is hash, optionally followed by failure reason
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		sb.ids = append(sb.ids, strings.TrimSpace(scanner.Text()))
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		sb.ids = append(sb.ids, fields[0])
+		if len(fields) > 1 {
+			sb.reasons[fields[0]] = FailureReason(fields[1])
+		}
 	}
 
 	fmt.Printf("[TXT] Loaded %d hashes in %v\n", len(sb.ids), time.Since(start))
@@ -37,7 +46,12 @@ func (sb *sandboxStruct) SaveBlacklist() {
 	writer := bufio.NewWriter(file)
 
 	for _, hash := range sb.ids {
-		_, err := writer.WriteString(hash + "\n")
+		line := hash
+		if reason := sb.reasons[hash]; reason != "" {
+			line += " " + string(reason)
+		}
+
+		_, err := writer.WriteString(line + "\n")
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,199 @@
+package sandbox
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+type FailureReason string
+
+const (
+	FailureURIParse   FailureReason = "uri_parse"
+	FailureConfig     FailureReason = "config"
+	FailureBlocked    FailureReason = "blocked"
+	FailureDNS        FailureReason = "dns"
+	FailureTCPRefused FailureReason = "tcp_refused"
+	FailureTLS        FailureReason = "tls"
+	FailureAuth       FailureReason = "auth"
+	FailureReset      FailureReason = "conn_reset"
+	FailureTimeout    FailureReason = "timeout"
+	FailureBadProbe   FailureReason = "bad_probe"
+	FailureUnknown    FailureReason = "unknown"
+)
+
+// Tie breaker when a node fails several ways equally often, earlier is more telling
+var failureReasons = []FailureReason{
+	FailureURIParse,
+	FailureConfig,
+	FailureBlocked,
+	FailureDNS,
+	FailureTCPRefused,
+	FailureTLS,
+	FailureAuth,
+	FailureReset,
+	FailureTimeout,
+	FailureBadProbe,
+	FailureUnknown,
+}
+
+// Error that already knows its reason
+type testFailureStruct struct {
+	reason FailureReason
+	err    error
+}
+
+func (failure *testFailureStruct) Error() string {
+	return failure.err.Error()
+}
+
+func (failure *testFailureStruct) Unwrap() error {
+	return failure.err
+}
+
+func makeFailure(reason FailureReason, err error) error {
+	return &testFailureStruct{
+		reason: reason,
+		err:    err,
+	}
+}
+
+func makeBadProbe(format string, a ...any) error {
+	return makeFailure(FailureBadProbe, fmt.Errorf(format, a...))
+}
+
+// Failed node, config kept like in results so sources can be blamed
+type FailureStruct struct {
+	RawConfig string
+	Reason    FailureReason
+	Error     string
+}
+
+// Guess why a test failed, sing-box mostly wraps errors so strings are the last resort
+func classifyFailure(err error) FailureReason {
+	var (
+		failure      *testFailureStruct
+		dnsError     *net.DNSError
+		netError     net.Error
+		recordError  tls.RecordHeaderError
+		alertError   tls.AlertError
+		unknownError x509.UnknownAuthorityError
+		hostError    x509.HostnameError
+		certError    x509.CertificateInvalidError
+	)
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &failure):
+		return failure.reason
+	case errors.Is(err, helper.ErrBlockedTarget):
+		return FailureBlocked
+	case errors.As(err, &dnsError):
+		return FailureDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureTCPRefused
+	case errors.As(err, &recordError), errors.As(err, &alertError), errors.As(err, &unknownError), errors.As(err, &hostError), errors.As(err, &certError):
+		return FailureTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError) && netError.Timeout():
+		return FailureTimeout
+	}
+
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "no such host"), strings.Contains(message, "lookup "), strings.Contains(message, "dns"):
+		return FailureDNS
+	case strings.Contains(message, "connection refused"):
+		return FailureTCPRefused
+	case strings.Contains(message, "tls"), strings.Contains(message, "x509"), strings.Contains(message, "certificate"), strings.Contains(message, "reality"):
+		return FailureTLS
+	case strings.Contains(message, "auth"), strings.Contains(message, "password"), strings.Contains(message, "invalid user"), strings.Contains(message, "decrypt"):
+		return FailureAuth
+	case strings.Contains(message, "timeout"), strings.Contains(message, "deadline exceeded"), strings.Contains(message, "timed out"):
+		return FailureTimeout
+	}
+
+	// Often a rejected uuid or password, but middleboxes hang up the same way
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || strings.HasSuffix(message, "eof") || strings.Contains(message, "connection reset") {
+		return FailureReset
+	}
+
+	return FailureUnknown
+}
+
+// Record why a unit failed, node keeps count of every reason
+func (node *testNodeStruct) addFailure(err error) {
+	node.Lock()
+	defer node.Unlock()
+
+	if node.failures == nil {
+		node.failures = map[FailureReason]int{}
+	}
+	node.failures[classifyFailure(err)] += 1
+	node.lastError = err.Error()
+}
+
+// Most frequent reason the node failed with, earliest in failureReasons on tie
+func (node *testNodeStruct) failureReason() FailureReason {
+	var reason FailureReason
+	for _, candidate := range failureReasons {
+		if node.failures[candidate] > node.failures[reason] {
+			reason = candidate
+		}
+	}
+
+	if reason == "" {
+		return FailureUnknown
+	}
+	return reason
+}
+
+func (sb *sandboxStruct) addFailure(rawConfig string, reason FailureReason, err string) {
+	sb.Lock()
+	defer sb.Unlock()
+
+	sb.Failures = append(sb.Failures, FailureStruct{
+		RawConfig: rawConfig,
+		Reason:    reason,
+		Error:     err,
+	})
+}
+
+// Failure count by reason, most common first
+func (sb *sandboxStruct) FailureSummary() string {
+	sb.Lock()
+	defer sb.Unlock()
+
+	var counts = map[FailureReason]int{}
+	for _, failure := range sb.Failures {
+		counts[failure.Reason] += 1
+	}
+
+	var reasons = []FailureReason{}
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.SliceStable(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] {
+			return counts[reasons[i]] > counts[reasons[j]]
+		}
+		return slices.Index(failureReasons, reasons[i]) < slices.Index(failureReasons, reasons[j])
+	})
+
+	var summary = []string{}
+	for _, reason := range reasons {
+		summary = append(summary, fmt.Sprintf("%s=%d", reason, counts[reason]))
+	}
+
+	return strings.Join(summary, " ")
+}
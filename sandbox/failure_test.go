@@ -0,0 +1,91 @@
+package sandbox
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		err  error
+		want FailureReason
+	}{
+		{"dns", &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, FailureDNS},
+		{"dns string", errors.New("lookup example.invalid: server misbehaving"), FailureDNS},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, FailureTCPRefused},
+		{"x509", fmt.Errorf("get: %w", x509.UnknownAuthorityError{}), FailureTLS},
+		{"tls string", errors.New("remote error: tls: handshake failure"), FailureTLS},
+		{"deadline", fmt.Errorf("probe: %w", context.DeadlineExceeded), FailureTimeout},
+		{"auth string", errors.New("hysteria2: authentication failed, status code: 404"), FailureAuth},
+		{"eof", fmt.Errorf("get: %w", io.EOF), FailureReset},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, FailureReset},
+		{"blocked", fmt.Errorf("%w: 10.0.0.1 is not public", helper.ErrBlockedTarget), FailureBlocked},
+		{"classified", makeFailure(FailureConfig, io.EOF), FailureConfig},
+		{"bad probe", makeBadProbe("probe responded with status %d", 403), FailureBadProbe},
+		{"unknown", errors.New("something else"), FailureUnknown},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := classifyFailure(test.err); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		failures map[FailureReason]int
+		want     FailureReason
+	}{
+		{"none", nil, FailureUnknown},
+		{"most frequent", map[FailureReason]int{FailureTLS: 1, FailureTimeout: 3}, FailureTimeout},
+		{"tie goes to earlier", map[FailureReason]int{FailureTimeout: 2, FailureTLS: 2}, FailureTLS},
+		{"tie with unknown", map[FailureReason]int{FailureUnknown: 1, FailureDNS: 1}, FailureDNS},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			node := testNodeStruct{failures: test.failures}
+			if got := node.failureReason(); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBlacklistRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Lines from before reasons were stored only hold the hash
+	if err := os.WriteFile(BLACKLIST_FILENAME, []byte("oldhash\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sb := MakeSandbox()
+	sb.LoadBlacklist()
+	sb.addBlacklist("newhash", FailureTLS)
+	sb.SaveBlacklist()
+
+	content, _ := os.ReadFile(BLACKLIST_FILENAME)
+	if string(content) != "oldhash\nnewhash tls\n" {
+		t.Fatalf("unexpected blacklist file: %q", content)
+	}
+
+	loaded := MakeSandbox()
+	loaded.LoadBlacklist()
+	if !loaded.isBlacklisted("oldhash") || !loaded.isBlacklisted("newhash") {
+		t.Errorf("hashes not loaded: %v", loaded.ids)
+	}
+	if loaded.reasons["newhash"] != FailureTLS || loaded.reasons["oldhash"] != "" {
+		t.Errorf("reasons not loaded: %v", loaded.reasons)
+	}
+}
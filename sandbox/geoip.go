@@ -0,0 +1,315 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fastshot "github.com/opus-domini/fast-shot"
+	"github.com/oschwald/maxminddb-golang"
+	N "github.com/sagernet/sing/common/network"
+	"golang.org/x/sync/singleflight"
+)
+
+const geoSourceMMDB = "mmdb"
+
+var (
+	asnPattern = regexp.MustCompile(`^(?i)AS(\d+)\s*`)
+
+	// Entry hosts are looked up with system resolver, bounded so a dead resolver can't stall the batch
+	lookupIPAddr       = net.DefaultResolver.LookupIPAddr
+	entryLookupTimeout = 5 * time.Second
+
+	// Fallback chain of echo endpoints, json or key=value text, also the -geo-endpoints default
+	DEFAULT_GEO_ENDPOINTS = []string{
+		"https://myip.ipeek.workers.dev",
+		"https://ipinfo.io/json",
+		"https://1.1.1.1/cdn-cgi/trace",
+	}
+)
+
+type mmdbCountryStruct struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+type mmdbASNStruct struct {
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+type geoResolverStruct struct {
+	countryReader *maxminddb.Reader
+	asnReader     *maxminddb.Reader
+	endpoints     []string
+	entries       map[string]configGeoipStruct // Entry geo by host, many units share one server
+	entryGroup    singleflight.Group
+	sync.Mutex
+}
+
+// Open MaxMind format country and asn databases, missing files are skipped
+func (sb *sandboxStruct) LoadGeoDatabases(countryPath, asnPath string) error {
+	for _, database := range []struct {
+		path   string
+		reader **maxminddb.Reader
+	}{
+		{countryPath, &sb.geo.countryReader},
+		{asnPath, &sb.geo.asnReader},
+	} {
+		if database.path == "" {
+			continue
+		}
+
+		reader, err := maxminddb.Open(database.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		*database.reader = reader
+	}
+
+	return nil
+}
+
+// Echo endpoints asked through node when probe response has no geo data
+func (sb *sandboxStruct) SetGeoEndpoints(endpoints []string) {
+	sb.geo.endpoints = []string{}
+	for _, endpoint := range endpoints {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			sb.geo.endpoints = append(sb.geo.endpoints, endpoint)
+		}
+	}
+}
+
+// Fill what probe response missed from echo chain, then prefer offline database for the exit ip
+func (geo *geoResolverStruct) resolve(dialer N.Dialer, configGeoip configGeoipStruct) configGeoipStruct {
+	for _, endpoint := range geo.endpoints {
+		if configGeoip.IP != "" && configGeoip.Country != "" {
+			break
+		}
+
+		httpClient := fastshot.NewClient(endpoint).
+			Config().SetCustomTransport(makeDialerTransport(dialer)).
+			Config().SetTimeout(5 * time.Second).
+			Build()
+
+		resp, err := httpClient.GET("").Send()
+		if err != nil {
+			continue
+		}
+
+		body, err := resp.Body().AsString()
+		if err != nil || resp.Status().Code() != 200 {
+			continue
+		}
+
+		configGeoip = mergeGeoip(configGeoip, parseGeoEcho(body, endpoint))
+	}
+
+	if ip := net.ParseIP(configGeoip.IP); ip != nil {
+		configGeoip = geo.lookup(ip, configGeoip)
+	}
+
+	// Post-processing geoip
+	filteredAsOrganization := orgPattern.FindAllString(configGeoip.AsOrganization, -1)
+	configGeoip.AsOrganization = strings.Join(filteredAsOrganization, " ")
+
+	if configGeoip.Country == "" {
+		configGeoip.Country = "XX"
+	}
+	if configGeoip.AsOrganization == "" {
+		configGeoip.AsOrganization = "Megalodon"
+	}
+
+	return configGeoip
+}
+
+// Geo of the server node dials first, resolved locally and only known with offline database
+func (geo *geoResolverStruct) resolveEntry(host string) configGeoipStruct {
+	if host == "" {
+		return configGeoipStruct{}
+	}
+
+	if entryGeoip, ok := geo.getEntry(host); ok {
+		return entryGeoip
+	}
+
+	// Units sharing a server ask at once, resolve it a single time
+	entryGeoip, _, _ := geo.entryGroup.Do(host, func() (any, error) {
+		if entryGeoip, ok := geo.getEntry(host); ok {
+			return entryGeoip, nil
+		}
+
+		entryGeoip := geo.lookupEntry(host)
+
+		geo.Lock()
+		geo.entries[host] = entryGeoip
+		geo.Unlock()
+
+		return entryGeoip, nil
+	})
+
+	return entryGeoip.(configGeoipStruct)
+}
+
+func (geo *geoResolverStruct) getEntry(host string) (configGeoipStruct, bool) {
+	geo.Lock()
+	defer geo.Unlock()
+
+	entryGeoip, ok := geo.entries[host]
+	return entryGeoip, ok
+}
+
+func (geo *geoResolverStruct) lookupEntry(host string) configGeoipStruct {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), entryLookupTimeout)
+		defer cancel()
+
+		if addrs, err := lookupIPAddr(ctx, host); err == nil && len(addrs) > 0 {
+			ip = addrs[0].IP
+			for _, candidate := range addrs {
+				if candidate.IP.To4() != nil {
+					ip = candidate.IP
+					break
+				}
+			}
+		}
+	}
+
+	if ip == nil {
+		return configGeoipStruct{}
+	}
+
+	entryGeoip := geo.lookup(ip, configGeoipStruct{IP: ip.String()})
+	entryGeoip.AsOrganization = strings.Join(orgPattern.FindAllString(entryGeoip.AsOrganization, -1), " ")
+
+	return entryGeoip
+}
+
+// Source tells where country came from, asn alone doesn't make it mmdb
+func (geo *geoResolverStruct) lookup(ip net.IP, configGeoip configGeoipStruct) configGeoipStruct {
+	if geo.countryReader != nil {
+		record := mmdbCountryStruct{}
+		if err := geo.countryReader.Lookup(ip, &record); err == nil {
+			country := record.Country.IsoCode
+			if country == "" {
+				country = record.RegisteredCountry.IsoCode
+			}
+
+			if country != "" {
+				configGeoip.Country = country
+				configGeoip.Source = geoSourceMMDB
+			}
+		}
+	}
+
+	if geo.asnReader != nil {
+		record := mmdbASNStruct{}
+		if err := geo.asnReader.Lookup(ip, &record); err == nil && record.AutonomousSystemNumber > 0 {
+			configGeoip.ASN = int64(record.AutonomousSystemNumber)
+			configGeoip.AsOrganization = record.AutonomousSystemOrganization
+		}
+	}
+
+	return configGeoip
+}
+
+// Keep what is known, take the rest from other
+func mergeGeoip(configGeoip, other configGeoipStruct) configGeoipStruct {
+	if configGeoip.IP == "" {
+		configGeoip.IP = other.IP
+	}
+	if configGeoip.Country == "" && other.Country != "" {
+		configGeoip.Country = other.Country
+		configGeoip.Source = other.Source
+	}
+	if configGeoip.AsOrganization == "" {
+		configGeoip.AsOrganization = other.AsOrganization
+	}
+	if configGeoip.ASN == 0 {
+		configGeoip.ASN = other.ASN
+	}
+
+	return configGeoip
+}
+
+// Understand the common echo formats, ipeek, ipinfo, ip-api, ipwho.is and cloudflare trace
+func parseGeoEcho(body, endpoint string) configGeoipStruct {
+	var (
+		configGeoip = configGeoipStruct{}
+		fields      = map[string]any{}
+	)
+
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		for _, line := range strings.Split(body, "\n") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+				fields[key] = value
+			}
+		}
+	}
+
+	if connection, ok := fields["connection"].(map[string]any); ok {
+		for key, value := range connection {
+			fields["connection_"+key] = value
+		}
+	}
+
+	configGeoip.IP = getGeoField(fields, "ip", "query")
+	configGeoip.AsOrganization = getGeoField(fields, "asOrganization", "as_org", "connection_org", "org", "isp")
+
+	// Some endpoints send full country name, only codes are usable
+	if country := getGeoField(fields, "country_code", "countryCode", "country", "loc"); len(country) == 2 {
+		configGeoip.Country = strings.ToUpper(country)
+	}
+
+	asn := getGeoField(fields, "asn", "connection_asn", "as")
+	if match := asnPattern.FindStringSubmatch(asn); match != nil {
+		asn = match[1]
+	}
+	configGeoip.ASN, _ = strconv.ParseInt(asn, 10, 64)
+
+	// Ipinfo puts asn in front of org
+	if match := asnPattern.FindStringSubmatch(configGeoip.AsOrganization); match != nil {
+		if configGeoip.ASN == 0 {
+			configGeoip.ASN, _ = strconv.ParseInt(match[1], 10, 64)
+		}
+		configGeoip.AsOrganization = strings.TrimPrefix(configGeoip.AsOrganization, match[0])
+	}
+
+	if configGeoip.Country != "" {
+		if parsedUrl, err := url.Parse(endpoint); err == nil {
+			configGeoip.Source = parsedUrl.Host
+		}
+	}
+
+	return configGeoip
+}
+
+func getGeoField(fields map[string]any, keys ...string) string {
+	for _, key := range keys {
+		switch value := fields[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case float64:
+			return strconv.FormatInt(int64(value), 10)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,231 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestResolveEntryOnce(t *testing.T) {
+	var lookups atomic.Int32
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return []net.IPAddr{{IP: net.ParseIP("2606:4700::1")}, {IP: net.ParseIP("1.1.1.1")}}, nil
+	}
+	defer func() {
+		lookupIPAddr = net.DefaultResolver.LookupIPAddr
+	}()
+
+	var (
+		sb = MakeSandbox()
+		wg = sync.WaitGroup{}
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if entryGeoip := sb.geo.resolveEntry("node.example.com"); entryGeoip.IP != "1.1.1.1" {
+				t.Errorf("got %q, want ipv4 preferred", entryGeoip.IP)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lookups.Load() != 1 {
+		t.Errorf("host looked up %d times, want once", lookups.Load())
+	}
+}
+
+func TestResolveEntryTimeout(t *testing.T) {
+	entryLookupTimeout = 100 * time.Millisecond
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	defer func() {
+		entryLookupTimeout = 5 * time.Second
+		lookupIPAddr = net.DefaultResolver.LookupIPAddr
+	}()
+
+	start := time.Now()
+	if entryGeoip := MakeSandbox().geo.resolveEntry("stuck.example.com"); entryGeoip.IP != "" {
+		t.Errorf("got %q from stuck resolver", entryGeoip.IP)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("lookup took %v, bound is %v", time.Since(start), entryLookupTimeout)
+	}
+}
+
+func TestParseGeoEcho(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		endpoint string
+		body     string
+		want     configGeoipStruct
+	}{
+		{
+			name:     "ipinfo",
+			endpoint: "https://ipinfo.io/json",
+			body:     `{"ip":"1.1.1.1","city":"Brisbane","country":"AU","org":"AS13335 Cloudflare, Inc."}`,
+			want:     configGeoipStruct{IP: "1.1.1.1", Country: "AU", AsOrganization: "Cloudflare, Inc.", ASN: 13335, Source: "ipinfo.io"},
+		},
+		{
+			name:     "ip-api",
+			endpoint: "http://ip-api.com/json",
+			body:     `{"status":"success","country":"Australia","countryCode":"AU","isp":"Cloudflare, Inc","as":"AS13335 Cloudflare, Inc.","query":"1.1.1.1"}`,
+			want:     configGeoipStruct{IP: "1.1.1.1", Country: "AU", AsOrganization: "Cloudflare, Inc", ASN: 13335, Source: "ip-api.com"},
+		},
+		{
+			name:     "ipwho",
+			endpoint: "https://ipwho.is/",
+			body:     `{"ip":"1.1.1.1","country":"Australia","country_code":"AU","connection":{"asn":13335,"org":"APNIC and Cloudflare DNS Resolver project","isp":"Cloudflare, Inc."}}`,
+			want:     configGeoipStruct{IP: "1.1.1.1", Country: "AU", AsOrganization: "APNIC and Cloudflare DNS Resolver project", ASN: 13335, Source: "ipwho.is"},
+		},
+		{
+			name:     "ipeek",
+			endpoint: "https://myip.ipeek.workers.dev",
+			body:     `{"ip":"1.1.1.1","country":"sg","asn":13335,"asOrganization":"Cloudflare"}`,
+			want:     configGeoipStruct{IP: "1.1.1.1", Country: "SG", AsOrganization: "Cloudflare", ASN: 13335, Source: "myip.ipeek.workers.dev"},
+		},
+		{
+			name:     "cloudflare trace",
+			endpoint: "https://1.1.1.1/cdn-cgi/trace",
+			body:     "fl=123f\nh=1.1.1.1\nip=1.1.1.1\nts=1700000000.1\nloc=SG\ntls=TLSv1.3\n",
+			want:     configGeoipStruct{IP: "1.1.1.1", Country: "SG", Source: "1.1.1.1"},
+		},
+		{
+			name:     "country name only",
+			endpoint: "https://echo.example.com",
+			body:     `{"ip":"1.1.1.1","country":"Singapore"}`,
+			want:     configGeoipStruct{IP: "1.1.1.1"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseGeoEcho(test.body, test.endpoint); got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// Encode MaxMind DB data field, only types geo records use
+func encodeMMDBField(value any) []byte {
+	control := func(fieldType, size int) []byte {
+		if size < 29 {
+			return []byte{byte(fieldType<<5 | size)}
+		}
+		return []byte{byte(fieldType<<5 | 29), byte(size - 29)}
+	}
+
+	switch value := value.(type) {
+	case string:
+		return append(control(2, len(value)), value...)
+	case uint32:
+		valueByte := binary.BigEndian.AppendUint32(nil, value)
+		for len(valueByte) > 0 && valueByte[0] == 0 {
+			valueByte = valueByte[1:]
+		}
+		return append(control(6, len(valueByte)), valueByte...)
+	case map[string]any:
+		fieldByte := control(7, len(value))
+		for _, key := range slices.Sorted(maps.Keys(value)) {
+			fieldByte = append(fieldByte, encodeMMDBField(key)...)
+			fieldByte = append(fieldByte, encodeMMDBField(value[key])...)
+		}
+		return fieldByte
+	}
+
+	panic(fmt.Sprintf("unsupported mmdb field %T", value))
+}
+
+// Ipv4 database of a single tree node, record holds 0.0.0.0/1 and the upper half is unknown
+func makeTestMMDB(t *testing.T, record map[string]any) *maxminddb.Reader {
+	var (
+		dataPointer = 1 + 16 // Node count and separator, record sits at data offset 0
+		buffer      = []byte{0, 0, byte(dataPointer), 0, 0, 1}
+	)
+	buffer = append(buffer, make([]byte, 16)...)
+	buffer = append(buffer, encodeMMDBField(record)...)
+	buffer = append(buffer, "\xAB\xCD\xEFMaxMind.com"...)
+	buffer = append(buffer, encodeMMDBField(map[string]any{
+		"node_count":  uint32(1),
+		"record_size": uint32(24),
+		"ip_version":  uint32(4),
+	})...)
+
+	reader, err := maxminddb.FromBytes(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestGeoLookup(t *testing.T) {
+	var (
+		countryReader = makeTestMMDB(t, map[string]any{
+			"country": map[string]any{"iso_code": "SG"},
+		})
+		registeredReader = makeTestMMDB(t, map[string]any{
+			"registered_country": map[string]any{"iso_code": "JP"},
+		})
+		asnReader = makeTestMMDB(t, map[string]any{
+			"autonomous_system_number":       uint32(13335),
+			"autonomous_system_organization": "Cloudflare",
+		})
+		echoGeoip = configGeoipStruct{IP: "1.1.1.1", Country: "AU", AsOrganization: "Echo Org", Source: "ipinfo.io"}
+	)
+
+	for _, test := range []struct {
+		name string
+		geo  *geoResolverStruct
+		ip   string
+		want configGeoipStruct
+	}{
+		{
+			name: "both databases",
+			geo:  &geoResolverStruct{countryReader: countryReader, asnReader: asnReader},
+			ip:   "1.1.1.1",
+			want: configGeoipStruct{IP: "1.1.1.1", Country: "SG", AsOrganization: "Cloudflare", ASN: 13335, Source: geoSourceMMDB},
+		},
+		{
+			name: "registered country",
+			geo:  &geoResolverStruct{countryReader: registeredReader},
+			ip:   "1.1.1.1",
+			want: configGeoipStruct{IP: "1.1.1.1", Country: "JP", AsOrganization: "Echo Org", Source: geoSourceMMDB},
+		},
+		{
+			name: "asn only keeps echo source",
+			geo:  &geoResolverStruct{asnReader: asnReader},
+			ip:   "1.1.1.1",
+			want: configGeoipStruct{IP: "1.1.1.1", Country: "AU", AsOrganization: "Cloudflare", ASN: 13335, Source: "ipinfo.io"},
+		},
+		{
+			name: "ip not in databases",
+			geo:  &geoResolverStruct{countryReader: countryReader, asnReader: asnReader},
+			ip:   "200.1.1.1",
+			want: echoGeoip,
+		},
+		{
+			name: "no databases",
+			geo:  &geoResolverStruct{},
+			ip:   "1.1.1.1",
+			want: echoGeoip,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.geo.lookup(net.ParseIP(test.ip), echoGeoip); got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
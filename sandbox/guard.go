@@ -0,0 +1,91 @@
+package sandbox
+
+import (
+	"context"
+	"net"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/adapter/outbound"
+	"github.com/sagernet/sing-box/common/dialer"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const (
+	guardOutboundType = "guard"
+	guardOutboundTag  = "guard"
+)
+
+// Outbound every node dials its server through. Server is resolved by sing-box dns,
+// so the bogon check has to happen on the address actually dialed, not on what system resolver says
+type guardOutboundStruct struct {
+	outbound.Adapter
+	checkedDialer N.Dialer
+	allowedDialer N.Dialer
+}
+
+func registerGuardOutbound(registry *outbound.Registry) {
+	outbound.Register[option.StubOptions](registry, guardOutboundType, makeGuardOutbound)
+}
+
+func makeGuardOutbound(ctx context.Context, router adapter.Router, logger log.ContextLogger, tag string, _ option.StubOptions) (adapter.Outbound, error) {
+	defaultDialer, err := dialer.NewDefault(ctx, option.DialerOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &guardOutboundStruct{
+		Adapter:       outbound.NewAdapter(guardOutboundType, tag, []string{N.NetworkTCP, N.NetworkUDP}, nil),
+		checkedDialer: dialer.NewResolveDialer(ctx, &publicDialerStruct{dialer: defaultDialer}, true, "", adapter.DNSQueryOptions{}, 0),
+		allowedDialer: dialer.NewResolveDialer(ctx, defaultDialer, true, "", adapter.DNSQueryOptions{}, 0),
+	}, nil
+}
+
+// Allowlisted hostnames are trusted whatever they resolve to
+func (guard *guardOutboundStruct) getDialer(destination M.Socksaddr) N.Dialer {
+	if destination.IsFqdn() && helper.IsAllowedHost(destination.Fqdn) {
+		return guard.allowedDialer
+	}
+
+	return guard.checkedDialer
+}
+
+func (guard *guardOutboundStruct) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	return guard.getDialer(destination).DialContext(ctx, network, destination)
+}
+
+func (guard *guardOutboundStruct) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return guard.getDialer(destination).ListenPacket(ctx, destination)
+}
+
+// Dialer rejecting resolved bogon destinations
+type publicDialerStruct struct {
+	dialer N.Dialer
+}
+
+func (publicDialer *publicDialerStruct) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if err := checkDestination(destination); err != nil {
+		return nil, err
+	}
+
+	return publicDialer.dialer.DialContext(ctx, network, destination)
+}
+
+func (publicDialer *publicDialerStruct) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	if err := checkDestination(destination); err != nil {
+		return nil, err
+	}
+
+	return publicDialer.dialer.ListenPacket(ctx, destination)
+}
+
+func checkDestination(destination M.Socksaddr) error {
+	if destination.IsFqdn() {
+		return helper.CheckPublicHost(destination.Fqdn)
+	}
+
+	return helper.CheckPublicIP(destination.Addr)
+}
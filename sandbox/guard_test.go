@@ -0,0 +1,72 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+func TestGuardBlocksResolvedBogon(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Close()
+		}
+	}()
+
+	// Passed the early check with public address, points at loopback once dialed
+	userinfo := base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:pass"))
+	node, err := MakeSandbox().prepareNode("ss://"+userinfo+"@1.1.1.1:8388#guarded", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbounds := getNodeOutboundMappings(node.singConfig)
+	outbounds[0]["server"] = "127.0.0.1"
+	outbounds[0]["server_port"] = listener.Addr().(*net.TCPAddr).Port
+
+	sharedBox, err := startSharedBox([]*testUnitStruct{{node: node, outbounds: outbounds}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sharedBox.close()
+
+	dialer, err := sharedBox.dialer(getUnitTag(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, N.NetworkTCP, M.ParseSocksaddr("example.com:80"))
+	if err == nil {
+		conn.Close()
+	}
+
+	if !errors.Is(err, helper.ErrBlockedTarget) || classifyFailure(err) != FailureBlocked {
+		t.Errorf("dial not blocked: %v", err)
+	}
+	if accepted.Load() > 0 {
+		t.Errorf("loopback server was reached")
+	}
+}
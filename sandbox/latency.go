@@ -0,0 +1,131 @@
+package sandbox
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"slices"
+	"time"
+
+	fastshot "github.com/opus-domini/fast-shot"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const defaultProbeCount = 3
+
+// Median timings in milliseconds, jitter is mean difference between consecutive ttfb
+type LatencyStruct struct {
+	Connect int64 `json:"connect"`
+	TLS     int64 `json:"tls"`
+	TTFB    int64 `json:"ttfb"`
+	Jitter  int64 `json:"jitter"`
+}
+
+// Faster of two, zero means not measured
+func (latency LatencyStruct) isBetterThan(other LatencyStruct) bool {
+	return other.TTFB == 0 || (latency.TTFB > 0 && latency.TTFB < other.TTFB)
+}
+
+// Time several fresh requests to probe url through outbound, error responses are left out
+func measureLatency(dialer N.Dialer, probeUrl string, probes int) (LatencyStruct, error) {
+	var (
+		connects, handshakes, ttfbs []int64
+		badStatus                   = 0
+	)
+
+	for range probes {
+		var (
+			start          = time.Now()
+			connectDone    time.Time
+			tlsStart       time.Time
+			tlsDone        time.Time
+			firstByte      time.Time
+			requestCtx, cl = context.WithTimeout(context.Background(), 5*time.Second)
+		)
+
+		trace := &httptrace.ClientTrace{
+			ConnectDone: func(string, string, error) {
+				connectDone = time.Now()
+			},
+			TLSHandshakeStart: func() {
+				tlsStart = time.Now()
+			},
+			TLSHandshakeDone: func(tls.ConnectionState, error) {
+				tlsDone = time.Now()
+			},
+			GotFirstResponseByte: func() {
+				firstByte = time.Now()
+			},
+		}
+
+		// New client each probe, so connection is never reused
+		resp, err := fastshot.NewClient(probeUrl).
+			Config().SetCustomTransport(makeDialerTransport(dialer)).
+			Config().SetTimeout(5 * time.Second).
+			Build().GET("").
+			Context().Set(httptrace.WithClientTrace(requestCtx, trace)).
+			Send()
+		if err != nil {
+			cl()
+			return LatencyStruct{}, err
+		}
+		resp.Body().Close()
+		cl()
+
+		// Error page is served by whatever is in between, its timing says nothing about the node
+		if resp.Status().Code() >= 400 {
+			badStatus = resp.Status().Code()
+			continue
+		}
+
+		// Plain http probes have no handshake
+		if tlsStart.IsZero() {
+			tlsStart, tlsDone = connectDone, connectDone
+		}
+
+		connects = append(connects, connectDone.Sub(start).Milliseconds())
+		handshakes = append(handshakes, tlsDone.Sub(tlsStart).Milliseconds())
+		ttfbs = append(ttfbs, firstByte.Sub(start).Milliseconds())
+	}
+
+	if len(ttfbs) == 0 && badStatus > 0 {
+		return LatencyStruct{}, makeBadProbe("latency probe responded with status %d", badStatus)
+	}
+
+	return LatencyStruct{
+		Connect: getMedian(connects),
+		TLS:     getMedian(handshakes),
+		TTFB:    getMedian(ttfbs),
+		Jitter:  getJitter(ttfbs),
+	}, nil
+}
+
+func getMedian(samples []int64) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
+
+func getJitter(samples []int64) int64 {
+	if len(samples) < 2 {
+		return 0
+	}
+
+	var total int64
+	for i := 1; i < len(samples); i++ {
+		diff := samples[i] - samples[i-1]
+		total += max(diff, -diff)
+	}
+
+	return total / int64(len(samples)-1)
+}
@@ -0,0 +1,99 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// Count hits, answer with status
+func makeStatusServer(hits *atomic.Int32, status func(hit int32) int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status(hits.Add(1)))
+		w.Write([]byte(`{"ip":"1.1.1.1","country":"SG","asOrganization":"Test Org"}`))
+	}))
+}
+
+func TestMeasureLatencyStatus(t *testing.T) {
+	var broken, flaky atomic.Int32
+
+	brokenServer := makeStatusServer(&broken, func(int32) int { return 503 })
+	defer brokenServer.Close()
+
+	// Every other response is an error page
+	flakyServer := makeStatusServer(&flaky, func(hit int32) int {
+		if hit%2 == 0 {
+			return 502
+		}
+		return 200
+	})
+	defer flakyServer.Close()
+
+	var failure *testFailureStruct
+	if _, err := measureLatency(N.SystemDialer, brokenServer.URL, 3); !errors.As(err, &failure) || failure.reason != FailureBadProbe {
+		t.Errorf("error pages timed: %v", err)
+	}
+
+	// Good samples still count
+	if _, err := measureLatency(N.SystemDialer, flakyServer.URL, 4); err != nil {
+		t.Errorf("flaky probe not timed: %v", err)
+	}
+}
+
+func TestProbeTimesAnsweringUrl(t *testing.T) {
+	var broken, working atomic.Int32
+
+	brokenServer := makeStatusServer(&broken, func(int32) int { return 404 })
+	defer brokenServer.Close()
+	workingServer := makeStatusServer(&working, func(int32) int { return 200 })
+	defer workingServer.Close()
+
+	mode := testModeStruct{ProbeURLs: []string{brokenServer.URL, workingServer.URL}, Probes: 2}
+	if _, err := probeThroughDialer(N.SystemDialer, mode); err != nil {
+		t.Fatal(err)
+	}
+
+	if broken.Load() != 1 || working.Load() != 3 {
+		t.Errorf("broken hit %d times, working %d, want latency probes on working url", broken.Load(), working.Load())
+	}
+}
+
+// Dialer taking a while before handing the conn over
+type slowDialerStruct struct {
+	N.Dialer
+	delay time.Duration
+}
+
+func (slowDialer slowDialerStruct) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	time.Sleep(slowDialer.delay)
+	return slowDialer.Dialer.DialContext(ctx, network, destination)
+}
+
+func TestMeasureLatencyConnect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	latency, err := measureLatency(slowDialerStruct{Dialer: N.SystemDialer, delay: 50 * time.Millisecond}, server.URL, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Connect ends once dialer returns, slow response only counts toward ttfb
+	if latency.Connect < 50 || latency.Connect >= 200 {
+		t.Errorf("connect took %dms, want dial time", latency.Connect)
+	}
+	if latency.TTFB < 250 {
+		t.Errorf("ttfb took %dms, want dial and response time", latency.TTFB)
+	}
+}
@@ -1,142 +1,257 @@
 package sandbox
 
 import (
-	"context"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"slices"
 	"sync"
-	"time"
 
 	"github.com/FoolVPN-ID/megalodon/common/helper"
+	"github.com/FoolVPN-ID/megalodon/constant"
 	logger "github.com/FoolVPN-ID/megalodon/log"
 	"github.com/FoolVPN-ID/tool/modules/config"
-	box "github.com/sagernet/sing-box"
-	"github.com/sagernet/sing-box/include"
+	C "github.com/sagernet/sing-box/constant"
 	"github.com/sagernet/sing-box/option"
 	"github.com/sagernet/sing/common/json"
 )
 
-var (
-	testTypes = []string{"cdn", "sni"}
-	sniHost   = "meet.google.com"
-	cdnHost   = "104.18.2.2"
-)
+var errBlacklisted = errors.New("dead account detected")
 
 type sandboxStruct struct {
-	Results []TestResultStruct
-	log     *logger.LoggerStruct
-	ids     []string
+	Results     []TestResultStruct
+	Failures    []FailureStruct
+	log         *logger.LoggerStruct
+	ids         []string
+	reasons     map[string]FailureReason // Why blacklisted, by md5
+	matrix      []testModeStruct
+	udpResolver string
+	geo         geoResolverStruct
+	maxResults  int
 	sync.Mutex
 }
 
 func MakeSandbox() *sandboxStruct {
 	return &sandboxStruct{
-		log: logger.MakeLogger(),
+		log:     logger.MakeLogger(),
+		matrix:  defaultTestMatrix,
+		reasons: map[string]FailureReason{},
+		geo: geoResolverStruct{
+			endpoints: DEFAULT_GEO_ENDPOINTS,
+			entries:   map[string]configGeoipStruct{},
+		},
 	}
 }
 
-func (sb *sandboxStruct) TestConfig(rawConfig string, accountIndex, accountTotal int) error {
+// Node under test along with the result its units fill in
+type testNodeStruct struct {
+	index      int
+	md5        string
+	singConfig option.Options
+	result     TestResultStruct
+	failures   map[FailureReason]int
+	lastError  string
+	sync.Mutex
+}
+
+func (sb *sandboxStruct) prepareNode(rawConfig string, index int) (*testNodeStruct, error) {
 	singConfig, err := config.BuildSingboxConfig(rawConfig)
 	if err != nil {
-		return err
+		return nil, makeFailure(FailureURIParse, err)
 	}
 
+	// Config builder prepends outbounds, chained node has its detour first
+	mainOutbound, detourOutbound := getNodeOutbounds(singConfig)
+
 	// Generate and check md5
 	var (
-		outboundByte, _ = json.Marshal(singConfig.Outbounds[0].Options)
+		outboundByte, _ = json.Marshal(mainOutbound.Options)
 		outboundMd5     = helper.GetMD5FromString(string(outboundByte))
 	)
 
-	if slices.Contains(sb.ids, outboundMd5) {
-		return errors.New("dead account detected")
+	if sb.isBlacklisted(outboundMd5) {
+		return nil, errBlacklisted
 	}
 
-	testResult := TestResultStruct{
-		Outbound:  singConfig.Outbounds[0],
-		RawConfig: base64.StdEncoding.EncodeToString([]byte(rawConfig)),
+	// Don't let nodes make us probe our own infra, names are checked by guard once sing-box resolves them
+	for _, outbound := range []option.Outbound{mainOutbound, detourOutbound} {
+		if server := getOutboundServer(outbound); server != "" {
+			if err := helper.CheckPublicLiteral(server); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	for _, testType := range testTypes {
-		singConfigMapping := map[string]any{}
-		singConfigByte, _ := json.Marshal(singConfig)
-		json.Unmarshal(singConfigByte, &singConfigMapping)
+	return &testNodeStruct{
+		index:      index,
+		md5:        outboundMd5,
+		singConfig: singConfig,
+		result: TestResultStruct{
+			Outbound:  mainOutbound,
+			Detour:    detourOutbound,
+			RawConfig: base64.StdEncoding.EncodeToString([]byte(rawConfig)),
+			Modes:     map[string]ModeResultStruct{},
+		},
+	}, nil
+}
 
-		outbound := singConfigMapping["outbounds"].([]any)[0].(map[string]any)
+// Node outbounds as mappings, direct outbound added by config builder left out
+func getNodeOutboundMappings(singConfig option.Options) []map[string]any {
+	singConfigMapping := map[string]any{}
+	singConfigByte, _ := json.Marshal(singConfig)
+	json.Unmarshal(singConfigByte, &singConfigMapping)
 
-		if testType == "cdn" {
-			outbound["server"] = cdnHost
-		} else {
-			if outbound["tls"] != nil {
-				outboundTLS := outbound["tls"].(map[string]any)
-				if outboundTLS["enabled"] == true {
-					outboundTLS["insecure"] = true
-					outboundTLS["server_name"] = sniHost
+	outbounds := []map[string]any{}
+	for _, outbound := range singConfigMapping["outbounds"].([]any) {
+		if outbound := outbound.(map[string]any); outbound["type"] != "direct" {
+			outbounds = append(outbounds, outbound)
+		}
+	}
 
-					outbound["tls"] = outboundTLS
-				}
-			}
+	return outbounds
+}
 
-			if outbound["transport"] != nil {
-				outboundTransport := outbound["transport"].(map[string]any)
-				if outboundTransport["headers"] != nil {
-					transportHeaders := outboundTransport["headers"].(map[string]any)
-					if transportHeaders["Host"] != nil {
-						transportHeaders["Host"] = sniHost
-					}
-					outboundTransport["headers"] = transportHeaders
-				}
-				if outboundTransport["host"] != nil {
-					outboundTransport["host"] = sniHost
-				}
+// Rewrite node outbound routed by final tag for a single matrix entry
+func applyTestEntry(outbounds []map[string]any, finalTag string, mode testModeStruct, entry TestEntryStruct) {
+	outbound := outbounds[0]
+	for _, candidate := range outbounds {
+		if candidate["tag"] == finalTag {
+			outbound = candidate
+		}
+	}
 
-				outbound["transport"] = outboundTransport
-			}
+	outboundType, _ := outbound["type"].(string)
+	isQUIC := slices.Contains(constant.QUIC_OUTBOUND_TYPES, outboundType)
+
+	// ShadowTLS detour dials its own server and carries the tls, main outbound only rides on it
+	for _, candidate := range outbounds {
+		if candidate["tag"] != outbound["detour"] || candidate["type"] != C.TypeShadowTLS {
+			continue
 		}
 
-		singConfigMapping["outbounds"].([]any)[0] = outbound
+		if outboundTLS, _ := candidate["tls"].(map[string]any); mode.Type == testModeSNI && outboundTLS != nil {
+			outboundTLS["insecure"] = true
+			outboundTLS["server_name"] = entry.Host
+		}
+		return
+	}
 
-		configForTest := option.Options{}
-		configForTestByte, err := json.Marshal(singConfigMapping)
-		if err != nil {
-			return err
+	if mode.Type == testModeCDN {
+		outbound["server"] = entry.Host
+	} else if isQUIC {
+		// QUIC always carries tls, builder may leave enabled out
+		outboundTLS, _ := outbound["tls"].(map[string]any)
+		if outboundTLS == nil {
+			outboundTLS = map[string]any{}
 		}
+		outboundTLS["enabled"] = true
+		outboundTLS["insecure"] = true
+		outboundTLS["server_name"] = entry.Host
+
+		outbound["tls"] = outboundTLS
+	} else {
+		if outbound["tls"] != nil {
+			outboundTLS := outbound["tls"].(map[string]any)
+			if outboundTLS["enabled"] == true {
+				outboundTLS["insecure"] = true
+				outboundTLS["server_name"] = entry.Host
 
-		ctx := context.Background()
-		ctx = box.Context(ctx, include.InboundRegistry(), include.OutboundRegistry(), include.EndpointRegistry(), include.DNSTransportRegistry(), include.ServiceRegistry())
-		err = configForTest.UnmarshalJSONContext(ctx, configForTestByte)
-		if err != nil {
-			return err
+				outbound["tls"] = outboundTLS
+			}
 		}
 
-		// Close closure variable
-		func(connMode string) {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			ctx = box.Context(ctx, include.InboundRegistry(), include.OutboundRegistry(), include.EndpointRegistry(), include.DNSTransportRegistry(), include.ServiceRegistry())
-			defer cancel()
-
-			if configGeoip, err := testSingConfigWithContext(configForTest, ctx); err == nil {
-				testResult.TestPassed = append(testResult.TestPassed, connMode)
-				testResult.ConfigGeoip = configGeoip
-				sb.log.Success(fmt.Sprintf("[%d/%d] [%d+%d] %v %s %s", accountIndex, accountTotal, len(sb.Results), len(testResult.TestPassed), testResult.TestPassed, configGeoip.Country, configGeoip.AsOrganization))
-			} else {
-				sb.log.Error(fmt.Sprintf("[%d/%d] %s", accountIndex, accountTotal, err.Error()))
+		if outbound["transport"] != nil {
+			outboundTransport := outbound["transport"].(map[string]any)
+			if outboundTransport["headers"] != nil {
+				transportHeaders := outboundTransport["headers"].(map[string]any)
+				if transportHeaders["Host"] != nil {
+					transportHeaders["Host"] = entry.Host
+				}
+				outboundTransport["headers"] = transportHeaders
+			}
+			if outboundTransport["host"] != nil {
+				outboundTransport["host"] = entry.Host
 			}
-		}(testType)
+
+			outbound["transport"] = outboundTransport
+		}
 	}
 
-	if len(testResult.TestPassed) > 0 {
-		sb.addResult(testResult)
-	} else {
-		sb.ids = append(sb.ids, outboundMd5)
+	// Matrix ports are tcp fronting ports, QUIC stays on its own udp port
+	if entry.Port > 0 && !isQUIC {
+		outbound["server_port"] = entry.Port
 	}
+}
 
-	return nil
+// Get the outbound routed by the node and the outbound it dials through, if any
+func getNodeOutbounds(singConfig option.Options) (option.Outbound, option.Outbound) {
+	var (
+		mainOutbound   = singConfig.Outbounds[0]
+		detourOutbound = option.Outbound{}
+	)
+
+	for _, outbound := range singConfig.Outbounds {
+		if outbound.Tag == singConfig.Route.Final {
+			mainOutbound = outbound
+		}
+	}
+
+	var (
+		outboundMapping = map[string]any{}
+		outboundByte, _ = json.Marshal(mainOutbound.Options)
+	)
+	json.Unmarshal(outboundByte, &outboundMapping)
+
+	if detour, ok := outboundMapping["detour"].(string); ok {
+		for _, outbound := range singConfig.Outbounds {
+			if outbound.Tag == detour {
+				detourOutbound = outbound
+			}
+		}
+	}
+
+	return mainOutbound, detourOutbound
+}
+
+func getOutboundServer(outbound option.Outbound) string {
+	var (
+		outboundMapping = map[string]any{}
+		outboundByte, _ = json.Marshal(outbound.Options)
+	)
+	json.Unmarshal(outboundByte, &outboundMapping)
+
+	server, _ := outboundMapping["server"].(string)
+	return server
+}
+
+// Stop keeping results once run has max of them, 0 keeps all
+func (sb *sandboxStruct) SetMaxResults(max int) {
+	sb.maxResults = max
+}
+
+func (sb *sandboxStruct) IsFull() bool {
+	sb.Lock()
+	defer sb.Unlock()
+	return sb.maxResults > 0 && len(sb.Results) >= sb.maxResults
 }
 
 func (sb *sandboxStruct) addResult(result TestResultStruct) {
 	sb.Lock()
 	defer sb.Unlock()
+	if sb.maxResults > 0 && len(sb.Results) >= sb.maxResults {
+		return
+	}
 	sb.Results = append(sb.Results, result)
 }
+
+func (sb *sandboxStruct) addBlacklist(id string, reason FailureReason) {
+	sb.Lock()
+	defer sb.Unlock()
+	sb.ids = append(sb.ids, id)
+	sb.reasons[id] = reason
+}
+
+func (sb *sandboxStruct) isBlacklisted(id string) bool {
+	sb.Lock()
+	defer sb.Unlock()
+	return slices.Contains(sb.ids, id)
+}
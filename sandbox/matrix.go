@@ -0,0 +1,130 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/FoolVPN-ID/megalodon/common/helper"
+)
+
+const (
+	testModeCDN = "cdn" // Dial host instead of node server
+	testModeSNI = "sni" // Send host as tls server name and http host
+)
+
+// Named mode of test matrix, every host and port pair is a matrix entry
+type testModeStruct struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Hosts     []string `json:"hosts"`
+	Ports     []int    `json:"ports,omitempty"`
+	ProbeURLs []string `json:"probe_urls,omitempty"`
+	Probes    int      `json:"probes,omitempty"` // Latency probes per entry, negative disables
+
+	// Optional download test, entry fails below min throughput in kilobits per second
+	ThroughputURL string `json:"throughput_url,omitempty"`
+	MinThroughput int64  `json:"min_throughput,omitempty"`
+}
+
+// Single matrix entry, zero port keeps node port
+type TestEntryStruct struct {
+	Mode string `json:"mode"`
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+}
+
+func (entry TestEntryStruct) String() string {
+	if entry.Port > 0 {
+		return fmt.Sprintf("%s:%d", entry.Host, entry.Port)
+	}
+
+	return entry.Host
+}
+
+var defaultProbeURLs = []string{
+	"https://myip.ipeek.workers.dev",
+}
+
+var defaultTestMatrix = []testModeStruct{
+	{
+		Name:  "cdn",
+		Type:  testModeCDN,
+		Hosts: []string{"104.18.2.2"},
+	},
+	{
+		Name:  "sni",
+		Type:  testModeSNI,
+		Hosts: []string{"meet.google.com"},
+	},
+}
+
+func (mode testModeStruct) entries() []TestEntryStruct {
+	var (
+		entries = []TestEntryStruct{}
+		ports   = mode.Ports
+	)
+
+	if len(ports) == 0 {
+		ports = []int{0}
+	}
+
+	for _, host := range mode.Hosts {
+		for _, port := range ports {
+			entries = append(entries, TestEntryStruct{
+				Mode: mode.Name,
+				Host: host,
+				Port: port,
+			})
+		}
+	}
+
+	return entries
+}
+
+func (mode testModeStruct) probeURLs() []string {
+	if len(mode.ProbeURLs) == 0 {
+		return defaultProbeURLs
+	}
+
+	return mode.ProbeURLs
+}
+
+func (mode testModeStruct) probes() int {
+	if mode.Probes == 0 {
+		return defaultProbeCount
+	}
+
+	return max(mode.Probes, 0)
+}
+
+// Replace default matrix with the one in file
+func (sb *sandboxStruct) LoadTestMatrix(path string) error {
+	matrixString, err := helper.ReadFileAsString(path)
+	if err != nil {
+		return err
+	}
+
+	matrix := []testModeStruct{}
+	if err := json.Unmarshal([]byte(matrixString), &matrix); err != nil {
+		return err
+	}
+
+	// Results and stats are keyed by mode name, it has to tell modes apart
+	names := map[string]bool{}
+	for _, mode := range matrix {
+		if mode.Type != testModeCDN && mode.Type != testModeSNI {
+			return fmt.Errorf("unknown test mode type %s: %s", mode.Type, mode.Name)
+		}
+		if strings.TrimSpace(mode.Name) == "" || len(mode.Hosts) == 0 {
+			return fmt.Errorf("test mode needs name and hosts: %s", mode.Name)
+		}
+		if names[mode.Name] {
+			return fmt.Errorf("duplicate test mode name: %s", mode.Name)
+		}
+		names[mode.Name] = true
+	}
+
+	sb.matrix = matrix
+	return nil
+}
@@ -0,0 +1,70 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTestMatrix(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		matrix  string
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			matrix: `[{"name": "cdn-443", "type": "cdn", "hosts": ["104.18.2.2"], "ports": [443]}, {"name": "sni", "type": "sni", "hosts": ["meet.google.com"]}]`,
+		},
+		{
+			name:    "duplicate name",
+			matrix:  `[{"name": "cdn", "type": "cdn", "hosts": ["104.18.2.2"]}, {"name": "cdn", "type": "sni", "hosts": ["meet.google.com"]}]`,
+			wantErr: "duplicate test mode name",
+		},
+		{
+			name:    "empty name",
+			matrix:  `[{"type": "cdn", "hosts": ["104.18.2.2"]}]`,
+			wantErr: "needs name and hosts",
+		},
+		{
+			name:    "blank name",
+			matrix:  `[{"name": " ", "type": "sni", "hosts": ["meet.google.com"]}]`,
+			wantErr: "needs name and hosts",
+		},
+		{
+			name:    "no hosts",
+			matrix:  `[{"name": "sni", "type": "sni"}]`,
+			wantErr: "needs name and hosts",
+		},
+		{
+			name:    "unknown type",
+			matrix:  `[{"name": "direct", "type": "direct", "hosts": ["1.1.1.1"]}]`,
+			wantErr: "unknown test mode type",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			matrixPath := filepath.Join(t.TempDir(), "test_matrix.json")
+			os.WriteFile(matrixPath, []byte(test.matrix), 0644)
+
+			sb := MakeSandbox()
+			err := sb.LoadTestMatrix(matrixPath)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(sb.matrix) != 2 || sb.matrix[0].Name != "cdn-443" {
+					t.Errorf("loaded %+v", sb.matrix)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got %v, want %q", err, test.wantErr)
+			}
+			if len(sb.matrix) != len(defaultTestMatrix) || sb.matrix[0].Name != defaultTestMatrix[0].Name {
+				t.Error("invalid matrix replaced default one")
+			}
+		})
+	}
+}
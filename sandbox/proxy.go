@@ -0,0 +1,104 @@
+package sandbox
+
+import (
+	std_bufio "bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/protocol/socks"
+)
+
+// Run node as local socks5 proxy through the outbound it passed with, so other parts can reach through it
+func (sb *sandboxStruct) StartProxy(result TestResultStruct) (string, func(), error) {
+	var lastErr = errors.New("no tested outbound")
+
+	for _, modeName := range result.TestPassed {
+		unit := result.Modes[modeName].unit
+		if unit == nil {
+			continue
+		}
+
+		sharedBox, err := startSharedBox([]*testUnitStruct{unit})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		// Node may have died since it was tested
+		dialer, err := sharedBox.dialer(getUnitTag(0))
+		if err == nil {
+			_, err = probeThroughDialer(dialer, unit.mode)
+		}
+		if err != nil {
+			sharedBox.close()
+			lastErr = err
+			continue
+		}
+
+		// Let kernel pick the port, nothing can take it between pick and bind
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			sharedBox.close()
+			return "", nil, err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go serveSocks(ctx, listener, dialer)
+
+		return fmt.Sprintf("socks5://%s", listener.Addr().String()), func() {
+			cancel()
+			listener.Close()
+			sharedBox.close()
+		}, nil
+	}
+
+	return "", nil, lastErr
+}
+
+func serveSocks(ctx context.Context, listener net.Listener, dialer N.Dialer) {
+	handler := &socksHandlerStruct{dialer: dialer}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			if err := socks.HandleConnectionEx(ctx, conn, std_bufio.NewReader(conn), nil, handler, nil, M.SocksaddrFromNet(conn.RemoteAddr()), nil); err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// Relay socks connections through outbound dialer, tcp only
+type socksHandlerStruct struct {
+	dialer N.Dialer
+}
+
+func (handler *socksHandlerStruct) NewConnectionEx(ctx context.Context, conn net.Conn, source M.Socksaddr, destination M.Socksaddr, onClose N.CloseHandlerFunc) {
+	remote, err := handler.dialer.DialContext(ctx, N.NetworkTCP, destination)
+	if err != nil {
+		N.ReportHandshakeFailure(conn, err)
+		conn.Close()
+		return
+	}
+
+	if err := N.ReportConnHandshakeSuccess(conn, remote); err != nil {
+		remote.Close()
+		conn.Close()
+		return
+	}
+
+	bufio.CopyConn(ctx, conn, remote)
+}
+
+func (handler *socksHandlerStruct) NewPacketConnectionEx(ctx context.Context, conn N.PacketConn, source M.Socksaddr, destination M.Socksaddr, onClose N.CloseHandlerFunc) {
+	conn.Close()
+}
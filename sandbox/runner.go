@@ -2,73 +2,92 @@ package sandbox
 
 import (
 	"context"
-	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
 	"regexp"
-	"strings"
 	"time"
 
-	"github.com/FoolVPN-ID/megalodon/common/helper"
 	fastshot "github.com/opus-domini/fast-shot"
-	box "github.com/sagernet/sing-box"
-	"github.com/sagernet/sing-box/option"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
 )
 
 var orgPattern = regexp.MustCompile(`(\w*)`)
-var connectivityTestList = []string{
-	"https://myip.ipeek.workers.dev",
-}
 
-func testSingConfigWithContext(singConfig option.Options, ctx context.Context) (configGeoipStruct, error) {
-	// Re-allocate free port
+// Probe mode urls through outbound of a single unit, then time and optionally measure throughput,
+// exit geo is what probe responses told and still has to be resolved
+func probeThroughDialer(dialer N.Dialer, mode testModeStruct) (ModeResultStruct, error) {
 	var (
-		freePort     = helper.GetFreePort()
-		mixedOptions = singConfig.Inbounds[0].Options.(*option.HTTPMixedInboundOptions)
+		configGeoip = configGeoipStruct{}
+		badStatus   = 0
+		latencyUrl  = ""
 	)
 
-	mixedOptions.ListenPort = uint16(freePort)
-	singConfig.Inbounds[0].Options = mixedOptions
-
-	configGeoip := configGeoipStruct{
-		Country:        "XX",
-		AsOrganization: "Megalodon",
-	}
-	boxInstance, err := box.New(box.Options{
-		Context: ctx,
-		Options: singConfig,
-	})
-	if err != nil {
-		return configGeoip, err
-	}
-
-	// Start sing-box
-	defer boxInstance.Close()
-	if err := boxInstance.Start(); err != nil {
-		return configGeoip, err
-	}
-
-	for _, connectivityTest := range connectivityTestList {
+	for _, connectivityTest := range mode.probeURLs() {
 		httpClient := fastshot.NewClient(connectivityTest).
-			Config().SetProxy(fmt.Sprintf("socks5://0.0.0.0:%d", int(freePort))).
+			Config().SetCustomTransport(makeDialerTransport(dialer)).
 			Config().SetTimeout(5 * time.Second).
 			Build()
 
 		resp, err := httpClient.GET("").Send()
 		if err != nil {
-			return configGeoip, err
+			return ModeResultStruct{}, err
 		} else {
-			if resp.Status().Code() == 200 {
-				resp.Body().AsJSON(&configGeoip)
+			if body, err := resp.Body().AsString(); err == nil && resp.Status().Code() == 200 {
+				configGeoip = mergeGeoip(configGeoip, parseGeoEcho(body, connectivityTest))
+			}
+			if resp.Status().Code() < 400 {
+				if latencyUrl == "" {
+					latencyUrl = connectivityTest
+				}
+			} else {
+				badStatus = resp.Status().Code()
 			}
 		}
 
-		// Post-processing geoip
-		filteredAsOrganization := orgPattern.FindAllString(configGeoip.AsOrganization, -1)
-		configGeoip.AsOrganization = strings.Join(filteredAsOrganization, " ")
-
 		if configGeoip.AsOrganization != "" && configGeoip.Country != "" {
 			break
 		}
 	}
 
-	return configGeoip, nil
+	// Error pages come from the node or something in between, not from probe urls
+	if latencyUrl == "" {
+		return ModeResultStruct{}, makeBadProbe("probe responded with status %d", badStatus)
+	}
+
+	// Node already passed, slow or flaky timing shouldn't fail it. Timed against url known to answer
+	latency, _ := measureLatency(dialer, latencyUrl, mode.probes())
+
+	throughput, err := checkThroughput(dialer, mode)
+	if err != nil {
+		return ModeResultStruct{}, err
+	}
+
+	return ModeResultStruct{
+		ExitGeoip:  configGeoip,
+		Latency:    latency,
+		Throughput: throughput,
+	}, nil
+}
+
+// Http transport dialing through outbound, fresh one means fresh connection
+func makeDialerTransport(dialer N.Dialer) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// Custom dial skips net dialer, so connect hooks of trace have to be called here
+			trace := httptrace.ContextClientTrace(ctx)
+			if trace != nil && trace.ConnectStart != nil {
+				trace.ConnectStart(network, addr)
+			}
+
+			conn, err := dialer.DialContext(ctx, network, M.ParseSocksaddr(addr))
+			if trace != nil && trace.ConnectDone != nil {
+				trace.ConnectDone(network, addr, err)
+			}
+
+			return conn, err
+		},
+		DisableKeepAlives: true,
+	}
 }
@@ -0,0 +1,62 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	fastshot "github.com/opus-domini/fast-shot"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const throughputMaxBytes = 10 << 20
+
+var throughputMaxDuration = 5 * time.Second
+
+// Measure mode throughput url if set, 0 if mode doesn't test throughput
+func checkThroughput(dialer N.Dialer, mode testModeStruct) (int64, error) {
+	if mode.ThroughputURL == "" {
+		return 0, nil
+	}
+
+	throughput, err := measureThroughput(dialer, mode.ThroughputURL)
+	if err != nil {
+		return 0, err
+	}
+	if throughput < mode.MinThroughput {
+		return throughput, makeBadProbe("throughput %d kbps below %d kbps", throughput, mode.MinThroughput)
+	}
+
+	return throughput, nil
+}
+
+// Download from url through outbound until time or size bound, returns speed in kbps
+func measureThroughput(dialer N.Dialer, downloadUrl string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), throughputMaxDuration)
+	defer cancel()
+
+	var start = time.Now()
+	resp, err := fastshot.NewClient(downloadUrl).
+		Config().SetCustomTransport(makeDialerTransport(dialer)).
+		Build().GET("").
+		Context().Set(ctx).
+		Send()
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body().Close()
+
+	// Running out of time is expected on slow nodes, speed so far still counts
+	written, err := io.CopyN(io.Discard, resp.Body().Raw(), throughputMaxBytes)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, context.DeadlineExceeded) {
+		return 0, err
+	}
+
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		return 0, nil
+	}
+
+	return int64(float64(written) * 8 / 1000 / elapsed), nil
+}
@@ -0,0 +1,82 @@
+package sandbox
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	N "github.com/sagernet/sing/common/network"
+)
+
+// Serve chunk every interval until client leaves or count runs out
+func makeSlowServer(chunk []byte, interval time.Duration, count int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for range count {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}))
+}
+
+func TestCheckThroughput(t *testing.T) {
+	throughputMaxDuration = 300 * time.Millisecond
+	defer func() {
+		throughputMaxDuration = 5 * time.Second
+	}()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1<<20)))
+	}))
+	defer fast.Close()
+
+	// Never finishes within bound, measured speed is what arrived until then
+	slow := makeSlowServer([]byte(strings.Repeat("x", 1024)), 50*time.Millisecond, 1000)
+	defer slow.Close()
+
+	for _, test := range []struct {
+		name    string
+		mode    testModeStruct
+		wantErr bool
+		wantMin int64
+		wantMax int64
+	}{
+		{"untested", testModeStruct{}, false, 0, 0},
+		{"fast", testModeStruct{ThroughputURL: fast.URL, MinThroughput: 800}, false, 800, -1},
+		{"timeout counts speed so far", testModeStruct{ThroughputURL: slow.URL}, false, 1, 800},
+		{"below minimum", testModeStruct{ThroughputURL: slow.URL, MinThroughput: 8000}, true, 1, 800},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				start           = time.Now()
+				throughput, err = checkThroughput(N.SystemDialer, test.mode)
+			)
+
+			if time.Since(start) > 2*throughputMaxDuration {
+				t.Errorf("took %v, bound is %v", time.Since(start), throughputMaxDuration)
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err %v, want error %t", err, test.wantErr)
+			}
+
+			var failure *testFailureStruct
+			if test.wantErr && (!errors.As(err, &failure) || failure.reason != FailureBadProbe) {
+				t.Errorf("err %v is not a bad probe", err)
+			}
+
+			if throughput < test.wantMin || (test.wantMax >= 0 && throughput > test.wantMax) {
+				t.Errorf("throughput %d kbps, want %d..%d", throughput, test.wantMin, test.wantMax)
+			}
+		})
+	}
+}
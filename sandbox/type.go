@@ -1,6 +1,9 @@
 package sandbox
 
-import "github.com/sagernet/sing-box/option"
+import (
+	"github.com/FoolVPN-ID/megalodon/common/shared"
+	"github.com/sagernet/sing-box/option"
+)
 
 type configGeoipStruct struct {
 	IP             string `json:"ip"`
@@ -8,11 +11,27 @@ type configGeoipStruct struct {
 	Port           int64  `json:"port"`
 	Country        string `json:"country"`
 	AsOrganization string `json:"asOrganization"`
+	ASN            int64  `json:"asn"`
+	Source         string `json:"source"` // Where geo came from, mmdb or echo endpoint host, empty if unknown
+}
+
+// What a single mode gives, taken from its fastest passed entry
+type ModeResultStruct struct {
+	ExitGeoip  configGeoipStruct
+	EntryGeoip configGeoipStruct // Server the node dials first, cdn host in cdn mode
+	Latency    LatencyStruct
+	Throughput int64 // Kilobits per second, 0 if not tested
+
+	unit *testUnitStruct // Rewritten outbounds the mode passed with
 }
 
 type TestResultStruct struct {
-	TestPassed  []string
-	ConfigGeoip configGeoipStruct
-	Outbound    option.Outbound
-	RawConfig   string
+	TestPassed    []string
+	PassedEntries []TestEntryStruct
+	Modes         map[string]ModeResultStruct
+	UDP           bool
+	Outbound      option.Outbound
+	Detour        option.Outbound
+	RawConfig     string
+	Userinfo      *shared.SubUserinfoStruct
 }
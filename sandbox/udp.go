@@ -0,0 +1,72 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const udpProbeDomain = "www.google.com"
+
+// Resolver the udp probe queries through node, empty disables the probe
+func (sb *sandboxStruct) SetUDPResolver(resolver string) {
+	sb.udpResolver = resolver
+}
+
+// Send dns query to resolver through packet conn of the unit outbound
+func probeUDP(dialer N.Dialer, resolver string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var resolverAddr = M.ParseSocksaddr(resolver)
+	packetConn, err := dialer.ListenPacket(ctx, resolverAddr)
+	if err != nil {
+		return err
+	}
+	conn := bufio.NewBindPacketConn(packetConn, resolverAddr.UDPAddr())
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	var (
+		queryId  = uint16(rand.IntN(1 << 16))
+		response = make([]byte, 512)
+	)
+	if _, err := conn.Write(buildDNSQuery(queryId, udpProbeDomain)); err != nil {
+		return err
+	}
+
+	n, err := conn.Read(response)
+	if err != nil {
+		return err
+	}
+
+	// Matching id with response bit set is enough, rcode doesn't matter
+	if n < 12 || binary.BigEndian.Uint16(response) != queryId || response[2]&0x80 == 0 {
+		return makeBadProbe("invalid dns response")
+	}
+
+	return nil
+}
+
+// Minimal recursive A query
+func buildDNSQuery(id uint16, domain string) []byte {
+	query := binary.BigEndian.AppendUint16(nil, id)
+	query = append(query, 0x01, 0x00) // Recursion desired
+	query = append(query, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	for _, label := range strings.Split(strings.Trim(domain, "."), ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0x00)
+
+	return append(query, 0x00, 0x01, 0x00, 0x01) // A, IN
+}
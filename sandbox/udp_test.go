@@ -0,0 +1,118 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	N "github.com/sagernet/sing/common/network"
+)
+
+func TestBuildDNSQuery(t *testing.T) {
+	query := buildDNSQuery(0x1234, "www.google.com.")
+
+	var want = []byte{
+		0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		3, 'w', 'w', 'w', 6, 'g', 'o', 'o', 'g', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0x00, 0x01, 0x00, 0x01,
+	}
+	if !bytes.Equal(query, want) {
+		t.Errorf("got % x, want % x", query, want)
+	}
+}
+
+// Local resolver answering every query with what respond makes of it
+func startUDPResponder(t *testing.T, respond func(query []byte) []byte) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			query := buffer[:n]
+			if !bytes.Equal(query[2:], buildDNSQuery(0, udpProbeDomain)[2:]) {
+				t.Errorf("unexpected query % x", query)
+				continue
+			}
+			conn.WriteToUDP(respond(query), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestProbeUDP(t *testing.T) {
+	// Header of reply to query, flags with response bit set
+	makeReply := func(id uint16, flags uint16) []byte {
+		reply := binary.BigEndian.AppendUint16(nil, id)
+		reply = binary.BigEndian.AppendUint16(reply, flags)
+		return append(reply, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	}
+
+	for _, test := range []struct {
+		name    string
+		respond func(query []byte) []byte
+		wantErr bool
+	}{
+		{
+			name: "answer",
+			respond: func(query []byte) []byte {
+				return makeReply(binary.BigEndian.Uint16(query), 0x8180)
+			},
+		},
+		{
+			name: "refused still proves udp",
+			respond: func(query []byte) []byte {
+				return makeReply(binary.BigEndian.Uint16(query), 0x8185)
+			},
+		},
+		{
+			name: "other id",
+			respond: func(query []byte) []byte {
+				return makeReply(binary.BigEndian.Uint16(query)+1, 0x8180)
+			},
+			wantErr: true,
+		},
+		{
+			name: "query echoed back",
+			respond: func(query []byte) []byte {
+				return query
+			},
+			wantErr: true,
+		},
+		{
+			name: "truncated",
+			respond: func(query []byte) []byte {
+				return query[:4]
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := probeUDP(N.SystemDialer, startUDPResponder(t, test.respond))
+			if !test.wantErr {
+				if err != nil {
+					t.Errorf("got %v, want pass", err)
+				}
+				return
+			}
+
+			var failure *testFailureStruct
+			if !errors.As(err, &failure) || failure.reason != FailureBadProbe {
+				t.Errorf("got %v, want bad probe", err)
+			}
+		})
+	}
+}